@@ -72,7 +72,7 @@ func idiv(env *Environment, args ...Value) (Value, Effect) {
 		return env.Fail(err)
 	}
 	if v2 == 0 {
-		return nil, ErrorFromString("division by 0")
+		return nil, ErrorWithKind(ArithmeticError, "division by 0")
 	}
 	return Int(v1 / v2), nil
 }
@@ -140,6 +140,39 @@ func teq(env *Environment, args ...Value) (Value, Effect) {
 	return Bool(t1 == t2), nil
 }
 
+func eq(env *Environment, args ...Value) (Value, Effect) {
+	var v1, v2 Value
+	err := Args(args, &v1, &v2)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return Bool(Equal(v1, v2)), nil
+}
+
+func cmp(env *Environment, args ...Value) (Value, Effect) {
+	var v1, v2 Value
+	err := Args(args, &v1, &v2)
+	if err != nil {
+		return env.Fail(err)
+	}
+	res := Compare(v1, v2)
+	if res > 0 {
+		return Int(1), nil
+	} else if res < 0 {
+		return Int(-1), nil
+	}
+	return Int(0), nil
+}
+
+func hash(env *Environment, args ...Value) (Value, Effect) {
+	var v1 Value
+	err := Args(args, &v1)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return Int(Hash(v1)), nil
+}
+
 func updateIntByName(update func(in Int) Int, env *Environment, args ...Value) (Int, Effect) {
 	var name Word
 	err := Args(args, &name)
@@ -149,7 +182,7 @@ func updateIntByName(update func(in Int) Int, env *Environment, args ...Value) (
 	val := env.Lookup(name.String())
 	vi, ok := val.(Int)
 	if !ok {
-		return Int(0), ErrorFromString("Not an integer.")
+		return Int(0), ErrorWithKind(TypeError, "Not an integer.")
 	}
 	newi := update(vi)
 	env.Set(name.String(), newi)
@@ -199,7 +232,7 @@ func boolBinop(op func(b1, b2 bool) bool, env *Environment, args ...Value) (Valu
 
 func isnil(env *Environment, args ...Value) (Value, Effect) {
 	if len(args) < 1 {
-		return env.FailString("isnil requires 1 argument")
+		return env.FailKind(ArgumentError, "isnil requires 1 argument")
 	}
 	return Bool(args[0] == nil), nil
 }
@@ -233,7 +266,7 @@ func bnot(env *Environment, args ...Value) (Value, Effect) {
 
 func val(env *Environment, args ...Value) (Value, Effect) {
 	if len(args) < 1 {
-		return env.FailString("val requres at least one argument.")
+		return env.FailKind(ArgumentError, "val requres at least one argument.")
 	}
 	return List(args), nil
 }
@@ -250,22 +283,90 @@ func ret(env *Environment, args ...Value) (Value, Effect) {
 
 func fail(env *Environment, args ...Value) (Value, Effect) {
 	if len(args) < 1 {
-		return env.Fail(ErrorFromString("fail"))
+		return env.Fail(ErrorWithKind(UserError, "fail"))
+	} else if err, ok := args[0].(*Error); ok && err != nil {
+		// Rethrow caught errors as they are.
+		return env.Fail(err)
+	} else if kind, ok := args[0].(Type); ok {
+		// fail kind message ?payload?
+		if len(args) < 2 {
+			return env.Fail(ErrorWithKind(kind, "fail"))
+		}
+		err := env.ErrorFromString(args[1].String(), args[2:]...)
+		err.Kind = kind
+		if len(args) > 2 {
+			err.Payload = args[2]
+		}
+		return env.Fail(err)
 	} else {
-		return env.FailString(args[0].String(), args[1:len(args)]...)
+		return env.FailKind(UserError, args[0].String(), args[1:len(args)]...)
 	}
 }
 
 func break_(env *Environment, args ...Value) (Value, Effect) {
+	if !env.InLoop() {
+		return env.FailString("break outside of loop")
+	}
 	if len(args) < 1 {
 		return env.Break(nil)
-	} else if len(args) == 1 {
+	}
+	// break label ?value? breaks out of the labeled loop.
+	if label, ok := args[0].(Word); ok && env.IsLabel(label) {
+		if len(args) > 1 {
+			return env.BreakLabel(label, args[1])
+		}
+		return env.BreakLabel(label, nil)
+	}
+	if len(args) == 1 {
 		return env.Break(args[0])
 	} else {
 		return env.Break(List(args))
 	}
 }
 
+func continue_(env *Environment, args ...Value) (Value, Effect) {
+	if !env.InLoop() {
+		return env.FailString("continue outside of loop")
+	}
+	if len(args) < 1 {
+		return env.Continue("")
+	}
+	var label Word
+	err := Args(args, &label)
+	if err != nil {
+		return env.Fail(err)
+	}
+	if !env.IsLabel(label) {
+		return env.FailKind(ArgumentError, "continue: no loop with label ${1}", label)
+	}
+	return env.Continue(label)
+}
+
+// loopLabel returns the optional label of a loop
+// from the argument at index.
+func loopLabel(args []Value, index int) (Word, *Error) {
+	var label Word
+	if len(args) <= index {
+		return label, nil
+	}
+	if _, ok := args[index].(Word); !ok {
+		return label, ErrorWithKind(ArgumentError, "loop label must be a word")
+	}
+	err := Convert(args[index], &label)
+	return label, err
+}
+
+func exit(env *Environment, args ...Value) (Value, Effect) {
+	var code Int
+	if len(args) > 0 {
+		err := Args(args, &code)
+		if err != nil {
+			return env.Fail(err)
+		}
+	}
+	return env.Exit(code)
+}
+
 func nop(env *Environment, args ...Value) (Value, Effect) {
 	return nil, nil
 }
@@ -293,7 +394,7 @@ func to(env *Environment, args ...Value) (Value, Effect) {
 	var name string
 
 	if len(args) < 2 {
-		return env.FailString("to needs at least 2 arguments")
+		return env.FailKind(ArgumentError, "to needs at least 2 arguments")
 	}
 	err := Convert(args[0], &name)
 	if err != nil {
@@ -338,10 +439,10 @@ func if_(env *Environment, args ...Value) (Value, Effect) {
 	var ifBlock, elseBlock Block
 
 	if len(args) < 2 {
-		return env.FailString("if needs at least 2 arguments")
+		return env.FailKind(ArgumentError, "if needs at least 2 arguments")
 	}
 	if len(args) > 4 {
-		return env.FailString("if needs at most 4 arguments")
+		return env.FailKind(ArgumentError, "if needs at most 4 arguments")
 	}
 	err := Convert(args[0], &cond)
 	if err != nil {
@@ -369,10 +470,10 @@ func if_(env *Environment, args ...Value) (Value, Effect) {
 		haveElse = true
 	}
 	if cond {
-		return ifBlock.Eval(env, args...)
+		return env.evalBranch(ifBlock, args...)
 	} else {
 		if haveElse {
-			return elseBlock.Eval(env, args...)
+			return env.evalBranch(elseBlock, args...)
 		} else {
 			return nil, nil
 		}
@@ -383,7 +484,7 @@ func switch_(env *Environment, args ...Value) (Value, Effect) {
 	var defaultBlock Block
 	var haveDefault bool = false
 	if len(args) < 3 {
-		return env.FailString("switch needs at least 3 arguments")
+		return env.FailKind(ArgumentError, "switch needs at least 3 arguments")
 	}
 	compareTo := args[0]
 	for i := 2; i < len(args); i += 2 {
@@ -402,21 +503,19 @@ func switch_(env *Environment, args ...Value) (Value, Effect) {
 			defaultBlock = block
 		} else {
 			if compareTo.String() == case_.String() {
-				return block.Eval(env, args...)
+				return env.evalBranch(block, args...)
 			}
 		}
 	}
 	if haveDefault {
-		return defaultBlock.Eval(env, args...)
+		return env.evalBranch(defaultBlock, args...)
 	}
 	return nil, nil
 }
 
 func while(env *Environment, args ...Value) (Value, Effect) {
-	var blockRes Value
-	var blockEff Effect
-	if len(args) != 2 {
-		return env.FailString("while needs exactly 3 arguments")
+	if len(args) != 2 && len(args) != 3 {
+		return env.FailKind(ArgumentError, "while needs 2 or 3 arguments")
 	}
 	cond, condOk := args[0].(Block)
 	block, blockOk := args[1].(Block)
@@ -426,19 +525,50 @@ func while(env *Environment, args ...Value) (Value, Effect) {
 	if !blockOk {
 		return env.FailString("while body must be a block")
 	}
+	label, err := loopLabel(args, 2)
+	if err != nil {
+		return env.Fail(err)
+	}
+	defer env.Label(label)()
+	return whileFrom(env, cond, block, label, nil, nil, args...)
+}
 
+// whileFrom runs the while loop. blockRes and blockEff are the result
+// of the previous iteration, if any.
+func whileFrom(env *Environment, cond, block Block, label Word, blockRes Value, blockEff Effect, args ...Value) (Value, Effect) {
+	var done bool
 	for res, eff := cond.Eval(env, args...); ValToBool(res); res, eff = cond.Eval(env, args...) {
 		if eff != nil && eff.Flow() > NormalFlow {
 			return res, eff
 		}
 		blockRes, blockEff = block.Eval(env, args...)
-		if blockEff != nil && blockEff.Flow() > NormalFlow {
+		if blockEff != nil && blockEff.Flow() == SuspendFlow {
+			return blockRes, env.suspendedLoop(blockEff, "while",
+				cond, block, label, List(args))
+		}
+		blockRes, blockEff, done = env.LoopEffect(label, blockRes, blockEff)
+		if done {
 			return blockRes, blockEff
 		}
 	}
 	return blockRes, blockEff
 }
 
+func resumeWhile(env *Environment, state List, val Value, eff Effect) (Value, Effect) {
+	var cond, block Block
+	var label Word
+	var args List
+	err := Args(state, &cond, &block, &label, &args)
+	if err != nil {
+		return env.Fail(err)
+	}
+	val, eff, done := env.LoopEffect(label, val, eff)
+	if done {
+		return val, eff
+	}
+	return whileFrom(env, cond, block, label, val, eff, args...)
+}
+
 func rescue(env *Environment, args ...Value) (Value, Effect) {
 	var block Block
 	err := Args(args, &block)
@@ -448,29 +578,136 @@ func rescue(env *Environment, args ...Value) (Value, Effect) {
 	return env.Prevent(block)
 }
 
+func defer_(env *Environment, args ...Value) (Value, Effect) {
+	var block Block
+	err := Args(args, &block)
+	if err != nil {
+		return env.Fail(err)
+	}
+	err = env.Defer(block)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return block, nil
+}
+
+// catchClause is a catch clause of a try command.
+type catchClause struct {
+	name  string
+	kind  string
+	block Block
+}
+
+// parseTry splits the arguments of try in the body,
+// the catch clauses and the finally block.
+func parseTry(args []Value) (body Block, catches []catchClause, finally *Block, err *Error) {
+	err = Args(args, &body)
+	if err != nil {
+		return body, nil, nil, err
+	}
+	for i := 1; i < len(args); {
+		if args[i] == nil {
+			return body, nil, nil, ErrorFromString("try: unexpected nil")
+		}
+		switch args[i].String() {
+		case "catch":
+			clause := catchClause{}
+			rest := args[i+1:]
+			if len(rest) > 0 && rest[0] != nil {
+				if _, isBlock := rest[0].(Block); !isBlock {
+					clause.name = rest[0].String()
+					rest = rest[1:]
+				}
+			}
+			if len(rest) > 0 && rest[0] != nil {
+				if _, isBlock := rest[0].(Block); !isBlock {
+					clause.kind = rest[0].String()
+					rest = rest[1:]
+				}
+			}
+			if len(rest) < 1 {
+				return body, nil, nil, ErrorFromString("try: catch needs a block")
+			}
+			err = Convert(rest[0], &clause.block)
+			if err != nil {
+				return body, nil, nil, err
+			}
+			catches = append(catches, clause)
+			i = len(args) - len(rest) + 1
+		case "finally":
+			if finally != nil || i+1 >= len(args) {
+				return body, nil, nil, ErrorFromString("try: finally needs one block")
+			}
+			finally = &Block{}
+			err = Convert(args[i+1], finally)
+			if err != nil {
+				return body, nil, nil, err
+			}
+			i += 2
+		default:
+			return body, nil, nil, ErrorFromString("try: expected catch or finally, not " + args[i].String())
+		}
+	}
+	return body, catches, finally, nil
+}
+
+// try evaluates the body block. If it fails, the first catch clause
+// without a kind or with the kind of the error evaluates it's block,
+// with the error in the named variable and in $1. Errors that are
+// not caught pass through. The finally block is evaluated afterwards
+// in all cases, and it's failure or return replaces the result.
+func try(env *Environment, args ...Value) (Value, Effect) {
+	body, catches, finally, err := parseTry(args)
+	if err != nil {
+		return env.Fail(err)
+	}
+	val, eff := body.Eval(env)
+	if eff != nil && eff.Flow() == FailFlow {
+		caught, _ := eff.(*Error)
+		for _, clause := range catches {
+			if clause.kind != "" && clause.kind != ErrorKind(caught).String() {
+				continue
+			}
+			if clause.name != "" {
+				env.Define(clause.name, caught, 0)
+			}
+			val, eff = clause.block.Eval(env, caught)
+			break
+		}
+	}
+	if finally != nil {
+		fval, feff := finally.Eval(env)
+		if feff != nil && feff.Flow() > NormalFlow &&
+			(eff == nil || eff.Flow() != ExitFlow) {
+			return fval, feff
+		}
+	}
+	return val, eff
+}
+
 func set(env *Environment, args ...Value) (Value, Effect) {
 	if len(args) < 2 {
-		return env.FailString("set needs at 2 arguments")
+		return env.FailKind(ArgumentError, "set needs at 2 arguments")
 	}
 	if args[0] == nil {
 		return env.FailString("set $1 is nil")
 	}
-	return env.Set(args[0].String(), args[1])
+	return env.Set(args[0].String(), isolate(args[1]))
 }
 
 func let(env *Environment, args ...Value) (Value, Effect) {
 	if len(args) < 2 {
-		return env.FailString("def needs at 2 arguments")
+		return env.FailKind(ArgumentError, "def needs at 2 arguments")
 	}
 	if args[0] == nil {
 		return env.FailString("def $1 is nil")
 	}
-	return env.Define(args[0].String(), args[1], 1)
+	return env.Define(args[0].String(), isolate(args[1]), 1)
 }
 
 func get(env *Environment, val ...Value) (Value, Effect) {
 	if len(val) < 1 {
-		return env.FailString("get needs at least 1 argument")
+		return env.FailKind(ArgumentError, "get needs at least 1 argument")
 	}
 	target := val[0].String()
 	return env.Lookup(target), nil
@@ -500,7 +737,7 @@ func sget(env *Environment, args ...Value) (Value, Effect) {
 	}
 	runes := []rune(str)
 	if (index < 0) || (index >= len(runes)) {
-		return env.FailString("index out of range")
+		return env.FailKind(IndexError, "index out of range")
 	}
 	return Int(runes[index]), nil
 }
@@ -549,8 +786,11 @@ func ladd(env *Environment, args ...Value) (Value, Effect) {
 	if err != nil {
 		return env.Fail(err)
 	}
-	list = append(list, value)
-	return list, nil
+	// Always copy so the result never shares storage with $1.
+	res := make(List, len(list), len(list)+1)
+	copy(res, list)
+	res = append(res, value)
+	return res, nil
 }
 
 func lget(env *Environment, args ...Value) (Value, Effect) {
@@ -561,7 +801,7 @@ func lget(env *Environment, args ...Value) (Value, Effect) {
 		return env.Fail(err)
 	}
 	if (index < 0) || (index >= len(list)) {
-		return env.FailString("index out of range")
+		return env.FailKind(IndexError, "index out of range")
 	}
 	return list[index], nil
 }
@@ -574,8 +814,12 @@ func lset(env *Environment, args ...Value) (Value, Effect) {
 	if err != nil {
 		return env.Fail(err)
 	}
+	err = Mutable(args[0])
+	if err != nil {
+		return env.Fail(err)
+	}
 	if (index < 0) || (index >= len(list)) {
-		return env.FailString("index out of range")
+		return env.FailKind(IndexError, "index out of range")
 	}
 	list[index] = val
 	return list[index], nil
@@ -599,26 +843,250 @@ func lsort(env *Environment, args ...Value) (Value, Effect) {
 	return list.SortStrings(), nil
 }
 
+// steps returns the amount of steps from from to to inclusive.
+func steps(from, to, step Int) (Int, *Error) {
+	if step == 0 {
+		return 0, ErrorWithKind(ArgumentError, "step may not be 0")
+	}
+	if (step > 0 && from > to) || (step < 0 && from < to) {
+		return 0, nil
+	}
+	return (to-from)/step + 1, nil
+}
+
+func repeat(env *Environment, args ...Value) (Value, Effect) {
+	var times Int
+	var block Block
+	err := Args(args, &times, &block)
+	if err != nil {
+		return env.Fail(err)
+	}
+	label, err := loopLabel(args, 2)
+	if err != nil {
+		return env.Fail(err)
+	}
+	err = env.CheckLoops(times)
+	if err != nil {
+		return env.Fail(err)
+	}
+	defer env.Label(label)()
+	return repeatFrom(env, times, block, label, 0, nil, nil)
+}
+
+// repeatFrom repeats the block from iteration i on. val and eff are
+// the result of the previous iteration, if any.
+func repeatFrom(env *Environment, times Int, block Block, label Word, i Int, val Value, eff Effect) (Value, Effect) {
+	var done bool
+	for ; i < times; i++ {
+		val, eff = block.Eval(env, i)
+		if eff != nil && eff.Flow() == SuspendFlow {
+			return val, env.suspendedLoop(eff, "repeat",
+				times, block, label, i+1)
+		}
+		val, eff, done = env.LoopEffect(label, val, eff)
+		if done {
+			break
+		}
+	}
+	return val, eff
+}
+
+func resumeRepeat(env *Environment, state List, val Value, eff Effect) (Value, Effect) {
+	var times, i Int
+	var block Block
+	var label Word
+	err := Args(state, &times, &block, &label, &i)
+	if err != nil {
+		return env.Fail(err)
+	}
+	val, eff, done := env.LoopEffect(label, val, eff)
+	if done {
+		return val, eff
+	}
+	return repeatFrom(env, times, block, label, i, val, eff)
+}
+
+func for_(env *Environment, args ...Value) (Value, Effect) {
+	var name Word
+	var from, to Int
+	step := Int(1)
+	var block Block
+	err := Args(args, &name, &from, &to)
+	if err != nil {
+		return env.Fail(err)
+	}
+	// for name from to ?step? {block} ?label?
+	index := 3
+	if len(args) > index {
+		if _, ok := args[index].(Block); !ok {
+			err = Convert(args[index], &step)
+			if err != nil {
+				return env.Fail(err)
+			}
+			index++
+		}
+	}
+	if len(args) <= index {
+		return env.FailKind(ArgumentError, "for: missing block")
+	}
+	err = Convert(args[index], &block)
+	if err != nil {
+		return env.Fail(err)
+	}
+	label, err := loopLabel(args, index+1)
+	if err != nil {
+		return env.Fail(err)
+	}
+	times, err := steps(from, to, step)
+	if err == nil {
+		err = env.CheckLoops(times)
+	}
+	if err != nil {
+		return env.Fail(err)
+	}
+	defer env.Label(label)()
+	return forFrom(env, name, from, step, times, block, label, 0, nil, nil)
+}
+
+// forFrom runs the for loop from iteration n on. val and eff are
+// the result of the previous iteration, if any.
+func forFrom(env *Environment, name Word, from, step, times Int, block Block, label Word, n Int, val Value, eff Effect) (Value, Effect) {
+	var done bool
+	for i := from + n*step; n < times; i, n = i+step, n+1 {
+		env.Define(name.String(), i, 0)
+		val, eff = block.Eval(env, i)
+		if eff != nil && eff.Flow() == SuspendFlow {
+			return val, env.suspendedLoop(eff, "for",
+				name, from, step, times, block, label, n+1)
+		}
+		val, eff, done = env.LoopEffect(label, val, eff)
+		if done {
+			break
+		}
+	}
+	return val, eff
+}
+
+func resumeFor(env *Environment, state List, val Value, eff Effect) (Value, Effect) {
+	var name, label Word
+	var from, step, times, n Int
+	var block Block
+	err := Args(state, &name, &from, &step, &times, &block, &label, &n)
+	if err != nil {
+		return env.Fail(err)
+	}
+	val, eff, done := env.LoopEffect(label, val, eff)
+	if done {
+		return val, eff
+	}
+	return forFrom(env, name, from, step, times, block, label, n, val, eff)
+}
+
+func range_(env *Environment, args ...Value) (Value, Effect) {
+	var from, to Int
+	step := Int(1)
+	err := Args(args, &to)
+	if err != nil {
+		return env.Fail(err)
+	}
+	// range n is from 0 up to but not including n,
+	// range from to ?step? includes to.
+	if len(args) > 1 {
+		from = to
+		err = Args(args[1:], &to)
+		if err == nil && len(args) > 2 {
+			err = Args(args[2:], &step)
+		}
+	} else {
+		to--
+	}
+	if err != nil {
+		return env.Fail(err)
+	}
+	times, err := steps(from, to, step)
+	if err == nil {
+		err = env.CheckLoops(times)
+	}
+	if err != nil {
+		return env.Fail(err)
+	}
+	res := make(List, 0, times)
+	for i, n := from, Int(0); n < times; i, n = i+step, n+1 {
+		res = append(res, i)
+	}
+	return res, nil
+}
+
 func leach(env *Environment, args ...Value) (Value, Effect) {
 	var list List
 	var key Word
 	var name Word
 	var block Block
-	err := Args(args, &list, &key, &name, &block)
+	var co *Coroutine
+	var cv *Channel
+	// Generators and channels are iterated lazily.
+	if Args(args, &co) == nil || Args(args, &cv) == nil {
+		err := Args(args[1:], &key, &name, &block)
+		if err != nil {
+			return env.Fail(err)
+		}
+	} else {
+		err := Args(args, &list, &key, &name, &block)
+		if err != nil {
+			return env.Fail(err)
+		}
+	}
+	label, err := loopLabel(args, 4)
 	if err != nil {
 		return env.Fail(err)
 	}
-	for i, v := range list {
-		env.Define(key.String(), Int(i), 0)
-		env.Define(name.String(), v, 0)
-		bval, berr := block.Eval(env, args...)
-		if berr != nil {
-			return bval, berr
+	if co != nil {
+		return leachCoroutine(env, co, key, name, block, label, args...)
+	} else if cv != nil {
+		return leachChannel(env, cv, key, name, block, label, args...)
+	}
+	defer env.Label(label)()
+	return leachFrom(env, list, key, name, block, label, 0, args...)
+}
+
+// leachFrom runs the leach loop over the list from index i on.
+func leachFrom(env *Environment, list List, key, name Word, block Block, label Word, i Int, args ...Value) (Value, Effect) {
+	for ; i < Int(len(list)); i++ {
+		env.Define(key.String(), i, 0)
+		env.Define(name.String(), list[i], 0)
+		bval, beff := block.Eval(env, args...)
+		if beff != nil && beff.Flow() == SuspendFlow {
+			return bval, env.suspendedLoop(beff, "leach",
+				list, key, name, block, label, i+1, List(args))
+		}
+		bval, beff, done := env.LoopEffect(label, bval, beff)
+		if beff != nil {
+			return bval, beff
+		} else if done {
+			break
 		}
 	}
 	return list, nil
 }
 
+func resumeLeach(env *Environment, state List, val Value, eff Effect) (Value, Effect) {
+	var list, args List
+	var key, name, label Word
+	var block Block
+	var i Int
+	err := Args(state, &list, &key, &name, &block, &label, &i, &args)
+	if err != nil {
+		return env.Fail(err)
+	}
+	val, eff, done := env.LoopEffect(label, val, eff)
+	if eff != nil {
+		return val, eff
+	} else if done {
+		return list, nil
+	}
+	return leachFrom(env, list, key, name, block, label, i, args...)
+}
+
 func lslice(env *Environment, args ...Value) (Value, Effect) {
 	var list List
 	var from Int
@@ -646,7 +1114,7 @@ func lslice(env *Environment, args ...Value) (Value, Effect) {
 	if from > to {
 		from, to = to, from
 	}
-	return list[from:to], nil
+	return Copy(list[from:to]), nil
 }
 
 func map_(env *Environment, args ...Value) (Value, Effect) {
@@ -659,43 +1127,151 @@ func map_(env *Environment, args ...Value) (Value, Effect) {
 	return res, nil
 }
 
+func dict(env *Environment, args ...Value) (Value, Effect) {
+	res, err := NewDict(args...)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return res, nil
+}
+
 func mget(env *Environment, args ...Value) (Value, Effect) {
-	var index string
-	var hmap Map
-	err := Args(args, &hmap, &index)
+	var key Value
+	var hmap Mapper
+	err := Args(args, &hmap, &key)
 	if err != nil {
 		return env.Fail(err)
 	}
-	return hmap[index], nil
+	val, _ := hmap.Get(key)
+	return val, nil
 }
 
 func mset(env *Environment, args ...Value) (Value, Effect) {
-	var index string
-	var hmap Map
+	var key Value
+	var hmap Mapper
 	var val Value
-	err := Args(args, &hmap, &index, &val)
+	err := Args(args, &hmap, &key, &val)
 	if err != nil {
 		return env.Fail(err)
 	}
-	hmap[index] = val
-	return hmap[index], nil
+	err = Mutable(args[0])
+	if err != nil {
+		return env.Fail(err)
+	}
+	err = hmap.Set(key, val)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return val, nil
 }
 
 func mkeys(env *Environment, args ...Value) (Value, Effect) {
-	var hmap Map
+	var hmap Mapper
+	err := Args(args, &hmap)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return hmap.Keys(), nil
+}
+
+func mdel(env *Environment, args ...Value) (Value, Effect) {
+	var key Value
+	var hmap Mapper
+	err := Args(args, &hmap, &key)
+	if err != nil {
+		return env.Fail(err)
+	}
+	err = Mutable(args[0])
+	if err != nil {
+		return env.Fail(err)
+	}
+	val, _ := hmap.Get(key)
+	hmap.Delete(key)
+	return val, nil
+}
+
+func mhas(env *Environment, args ...Value) (Value, Effect) {
+	var key Value
+	var hmap Mapper
+	err := Args(args, &hmap, &key)
+	if err != nil {
+		return env.Fail(err)
+	}
+	_, ok := hmap.Get(key)
+	return Bool(ok), nil
+}
+
+func mlen(env *Environment, args ...Value) (Value, Effect) {
+	var hmap Mapper
+	err := Args(args, &hmap)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return Int(hmap.Len()), nil
+}
+
+func mvalues(env *Environment, args ...Value) (Value, Effect) {
+	var hmap Mapper
+	err := Args(args, &hmap)
+	if err != nil {
+		return env.Fail(err)
+	}
+	res := List{}
+	for _, k := range OrderedKeys(hmap) {
+		v, _ := hmap.Get(k)
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+func mpairs(env *Environment, args ...Value) (Value, Effect) {
+	var hmap Mapper
 	err := Args(args, &hmap)
 	if err != nil {
 		return env.Fail(err)
 	}
 	res := List{}
-	for k, _ := range hmap {
-		res = append(res, String(k))
+	for _, k := range OrderedKeys(hmap) {
+		v, _ := hmap.Get(k)
+		res = append(res, List{k, v})
+	}
+	return res, nil
+}
+
+func mmerge(env *Environment, args ...Value) (Value, Effect) {
+	maps := []Mapper{}
+	ordered := false
+	for i := 0; i < len(args); i++ {
+		var hmap Mapper
+		err := Convert(args[i], &hmap)
+		if err != nil {
+			return env.Fail(err)
+		}
+		if _, isDict := hmap.(*Dict); isDict {
+			ordered = true
+		}
+		maps = append(maps, hmap)
+	}
+	// The result is a Dict if any of the arguments is one,
+	// so no ordering or keys are lost.
+	var res Mapper = make(Map)
+	if ordered {
+		res, _ = NewDict()
+	}
+	for _, hmap := range maps {
+		for _, k := range OrderedKeys(hmap) {
+			v, _ := hmap.Get(k)
+			err := res.Set(k, v)
+			if err != nil {
+				return env.Fail(err)
+			}
+		}
 	}
 	return res, nil
 }
 
 func meach(env *Environment, args ...Value) (Value, Effect) {
-	var map_ Map
+	var map_ Mapper
 	var key Word
 	var name Word
 	var block Block
@@ -703,22 +1279,164 @@ func meach(env *Environment, args ...Value) (Value, Effect) {
 	if err != nil {
 		return env.Fail(err)
 	}
-	miter := Map{}
-
-	for k, v := range map_ {
-		miter[k] = v
+	// Iterate over a snapshot of the keys so the block may
+	// modify the map safely.
+	keys := map_.Keys()
+	// meach map key value {block} ?sorted|unsorted? ?label?
+	if len(args) > 4 {
+		var order Word
+		err = Convert(args[4], &order)
+		if err != nil {
+			return env.Fail(err)
+		}
+		if order == "sorted" {
+			keys = map_.SortedKeys()
+		} else if order != "unsorted" {
+			return env.FailString("meach: unknown option ${1}", order)
+		}
+	}
+	label, err := loopLabel(args, 5)
+	if err != nil {
+		return env.Fail(err)
 	}
-	for k, v := range miter {
-		env.Define(key.String(), String(k), 0)
+	defer env.Label(label)()
+	return meachFrom(env, map_, keys, key, name, block, label, 0, args...)
+}
+
+// meachFrom runs the meach loop over the keys from index i on.
+func meachFrom(env *Environment, map_ Mapper, keys List, key, name Word, block Block, label Word, i Int, args ...Value) (Value, Effect) {
+	for ; i < Int(len(keys)); i++ {
+		k := keys[i]
+		v, ok := map_.Get(k)
+		if !ok { // deleted by the block
+			continue
+		}
+		env.Define(key.String(), k, 0)
 		env.Define(name.String(), v, 0)
 		bval, beff := block.Eval(env, args...)
+		if beff != nil && beff.Flow() == SuspendFlow {
+			return bval, env.suspendedLoop(beff, "meach",
+				map_, keys, key, name, block, label, i+1, List(args))
+		}
+		bval, beff, done := env.LoopEffect(label, bval, beff)
 		if beff != nil {
 			return bval, beff
+		} else if done {
+			break
 		}
 	}
 	return map_, nil
 }
 
+func resumeMeach(env *Environment, state List, val Value, eff Effect) (Value, Effect) {
+	var map_ Mapper
+	var keys, args List
+	var key, name, label Word
+	var block Block
+	var i Int
+	err := Args(state, &map_, &keys, &key, &name, &block, &label, &i, &args)
+	if err != nil {
+		return env.Fail(err)
+	}
+	val, eff, done := env.LoopEffect(label, val, eff)
+	if eff != nil {
+		return val, eff
+	} else if done {
+		return map_, nil
+	}
+	return meachFrom(env, map_, keys, key, name, block, label, i, args...)
+}
+
+func freeze(env *Environment, args ...Value) (Value, Effect) {
+	var val Value
+	err := Args(args, &val)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return Freeze(val), nil
+}
+
+func frozen(env *Environment, args ...Value) (Value, Effect) {
+	var val Value
+	err := Args(args, &val)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return Bool(IsFrozen(val)), nil
+}
+
+func copy_(env *Environment, args ...Value) (Value, Effect) {
+	var val Value
+	err := Args(args, &val)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return Copy(val), nil
+}
+
+func deepcopy(env *Environment, args ...Value) (Value, Effect) {
+	var val Value
+	err := Args(args, &val)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return DeepCopy(val), nil
+}
+
+func jsonEncode(env *Environment, args ...Value) (Value, Effect) {
+	var val Value
+	err := Args(args, &val)
+	if err != nil {
+		return env.Fail(err)
+	}
+	opts := JSONOptions{}
+	for _, arg := range args[1:] {
+		var option Word
+		err = Convert(arg, &option)
+		if err != nil {
+			return env.Fail(err)
+		}
+		switch option {
+		case "pretty":
+			opts.Indent = "\t"
+		case "sorted":
+			opts.SortKeys = true
+		default:
+			return env.FailString("json_encode: unknown option ${1}", option)
+		}
+	}
+	buf, err := EncodeJSON(val, opts)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return String(buf), nil
+}
+
+func jsonDecode(env *Environment, args ...Value) (Value, Effect) {
+	var text string
+	err := Args(args, &text)
+	if err != nil {
+		return env.Fail(err)
+	}
+	ordered := false
+	if len(args) > 1 {
+		var option Word
+		err = Convert(args[1], &option)
+		if err != nil {
+			return env.Fail(err)
+		}
+		if option != "ordered" {
+			return env.FailString("json_decode: unknown option ${1}", option)
+		}
+		ordered = true
+	}
+	val, err := DecodeJSON([]byte(text), ordered)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return val, nil
+}
+
 func expand(env *Environment, args ...Value) (Value, Effect) {
 	var msg string
 	err := Args(args, &msg)
@@ -783,7 +1501,7 @@ func overload(env *Environment, args ...Value) (Value, Effect) {
 		return env.Fail(err)
 	}
 	if len(args) < 3 {
-		return env.FailString("overload needs at least 3 arguments")
+		return env.FailKind(ArgumentError, "overload needs at least 3 arguments")
 	}
 	return env.Overload(name, target, args[2:len(args)])
 }
@@ -797,6 +1515,9 @@ func (env *Environment) Register(name string,
 func (env *Environment) RegisterBuiltins() {
 	env.Define("true", Bool(true), -1)
 	env.Define("false", Bool(false), -1)
+	for _, kind := range ErrorKinds {
+		env.Define(kind.String(), kind, -1)
+	}
 	env.Register("sadd", sadd, "returns a string  with $2 appended to string $1")
 	env.Register("sget", sget, "gets a rune from a string by index")
 	env.Register("slen", slen, "returns the length of a string")
@@ -808,10 +1529,25 @@ func (env *Environment) RegisterBuiltins() {
 	env.Register("ladd", ladd, "returns a list with $2 appended to List $1")
 	env.Register("list", list, "creates a new array list")
 	env.Register("lget", lget, "gets a value from a list by index")
-	env.Register("lset", lset, "sets a value to a list by index and value")
+	env.Register("lset", lset, "sets a value to a list by index and value, modifying the list in place")
 	env.Register("llen", llen, "returns the length of a list")
 	env.Register("lsort", lsort, "returns the List $1 sorted by string value")
-	env.Register("leach", leach, "calls the block $4 for each entry in the list")
+	env.Register("repeat", repeat, "executes block $2 $1 times, with the iteration in $1 of the block and optional loop label $3")
+	env.Register("for", for_, "executes block $5 with variable $1 from $2 to $3 inclusive, with optional step $4 and loop label")
+	env.Register("range", range_, "returns a list of Int from 0 up to $1, or from $1 to $2 inclusive with optional step $3")
+	env.Register("leach", leach, "calls the block $4 for each entry in the list or for each value of the generator, with optional loop label $5")
+	env.Register("generator", generator, "returns a coroutine that evaluates block $1 with the other arguments when it is resumed")
+	env.Register("coroutine", generator, "returns a coroutine that evaluates block $1 with the other arguments when it is resumed")
+	env.Register("yield", yield, "suspends the current coroutine with value $1, and returns the value it is resumed with")
+	env.Register("resume", resume, "resumes coroutine $1 with value $2, and returns the value it yields, or the result of it's block when done")
+	env.Register("next", next_, "returns the next value the generator $1 yields, or nil if it is done")
+	env.Register("done", done, "returns true if coroutine $1 is done")
+	env.Register("global", global, "gets the shared variable $1, or sets it to a frozen copy of $2")
+	env.Register("checkpoint", checkpoint, "suspends a resumable script with the label $1, evaluates to the value it is resumed with")
+	env.Register("await", await, "suspends a resumable script with the label $2, and sets the variable $1 to the value it is resumed with")
+	env.Register("serialize", serialize, "serializes $1 to a string that deserialize turns back into the same value")
+	env.Register("deserialize", deserialize, "deserializes a value that was serialized with serialize")
+	env.Register("stop", stop, "stops the suspended coroutine $1, running it's deferred blocks")
 	env.Register("lslice", lslice, "slices the list $1 from $2 to $3")
 	env.Register("iadd", iadd, "adds and Ints to and Int")
 	env.Register("isub", isub, "subtracts an Int from an Int")
@@ -823,6 +1559,9 @@ func (env *Environment) RegisterBuiltins() {
 	env.Register("ige", ige, "checks if $1 >= $2, where $1 and $2 must be Int")
 	env.Register("ieq", ieq, "checks if $1 == $2, where $1 and $2 must be Int")
 	env.Register("seq", seq, "checks if [str $1] == [str $2]")
+	env.Register("eq", eq, "checks if $1 and $2 have the same type and are deeply equal")
+	env.Register("cmp", cmp, "compares $1 and $2 and returns -1, 0 or 1")
+	env.Register("hash", hash, "returns a hash code of $1 as an Int")
 	env.Register("str", str, "converts $1 to String")
 	env.Register("wire", wire, "converts unicode character indexes or runes to String")
 	env.Register("runes", runes, "converts String to alist of character indexes or runes")
@@ -830,19 +1569,85 @@ func (env *Environment) RegisterBuiltins() {
 	env.Register("inc", inc, "increments the named integer $1")
 	env.Register("dec", dec, "decrements the named integer $1")
 	env.Register("map", map_, "creates a new hash map")
-	env.Register("mget", mget, "gets a value from a map by key")
-	env.Register("mset", mset, "sets a value to a map by key and value")
-	env.Register("mkeys", mkeys, "returns all keys of a map as an unsorted list")
-	env.Register("meach", meach, "calls the block $4 for each entry in the map")
+	env.Register("dict", dict, "creates a new insertion ordered dictionary")
+	env.Register("mget", mget, "gets a value from a map or dict by key")
+	env.Register("mset", mset, "sets a value to a map or dict by key and value, modifying it in place")
+	env.Register("mkeys", mkeys, "returns all keys of a map as an unsorted list, or of a dict in insertion order")
+	env.Register("meach", meach, "calls the block $4 for each entry in the map or dict, in key order if $5 is sorted, with optional loop label $6")
+	env.Register("mdel", mdel, "deletes a key from a map and returns the deleted value")
+	env.Register("mhas", mhas, "returns true if the map $1 contains the key $2")
+	env.Register("mlen", mlen, "returns the amount of entries in a map")
+	env.Register("mvalues", mvalues, "returns all values of a map sorted by key, or of a dict in insertion order")
+	env.Register("mpairs", mpairs, "returns a list of [list key value] pairs of a map sorted by key, or of a dict in insertion order")
+	env.Register("mmerge", mmerge, "returns a new map, or dict if any argument is a dict, with the entries of all arguments, later ones take precedence")
+	env.Register("freeze", freeze, "returns a read only deep copy of $1")
+	env.Register("frozen", frozen, "returns true if $1 is read only")
+	env.Register("copy", copy_, "returns a modifiable shallow copy of the list, map or dict $1")
+	env.Register("deepcopy", deepcopy, "returns a modifiable deep copy of $1")
+	env.Register("json_encode", jsonEncode, "encodes $1 as JSON, options pretty to indent and sorted to sort dict keys")
+	env.Register("json_decode", jsonDecode, "decodes the JSON text $1, objects become a dict if $2 is ordered, or a map otherwise")
+	env.Register("path_join", pathJoin, "joins the arguments into a slash separated path")
+	env.Register("path_base", pathBase, "returns the last element of the path $1")
+	env.Register("path_dir", pathDir, "returns all but the last element of the path $1")
+	env.Register("path_ext", pathExt, "returns the file name extension of the path $1, including the dot")
+	env.Register("path_clean", pathClean, "returns the shortest path equivalent to path $1")
+	env.Register("path_rel", pathRel, "returns path $2 relative to path $1")
+	env.Register("path_match", pathMatch, "returns true if path $2 matches the glob pattern $1")
+	env.Register("glob", glob, "returns a sorted list of the names in list $2 that match the glob pattern $1, or of the matching files if $2 is not given")
+	env.Register("csv_read", csvRead, "reads CSV from String $1, or from the reader if $1 is in, with optional options map, as a list of rows or calls the block for each row with the row in the named variable and an optional loop label")
+	env.Register("csv_write", csvWrite, "writes the list of rows $1 as CSV to a String or to the writer, with an optional options map")
+	env.Register("now", now, "returns the current time")
+	env.Register("duration", duration, "returns a duration from String $1 like 1h30m, or from Int $1 in milliseconds")
+	env.Register("parse_time", parseTime, "parses String $1 as a time with layout $2, rfc3339 by default, in time zone $3, UTC by default")
+	env.Register("format_time", formatTime, "formats time $1 with layout $2, rfc3339 by default")
+	env.Register("tadd", tadd, "adds duration $2 to time or duration $1")
+	env.Register("tsub", tsub, "subtracts duration $2 from time or duration $1")
+	env.Register("tdiff", tdiff, "returns the duration between time $2 and time $1")
+	env.Register("tz", tz, "returns time $1 in the time zone named $2")
+	env.Register("tunix", tunix, "returns time $1 as Unix seconds, or the time of Unix seconds $1")
+	env.Register("sleep", sleep, "waits for duration $1, or until the script is canceled")
+	env.Register("rand_int", randInt, "returns a random Int from 0 up to but not including $1, or from $1 up to and including $2")
+	env.Register("rand_choice", randChoice, "returns a random element of list $1")
+	env.Register("rand_shuffle", randShuffle, "returns a shuffled copy of list $1")
+	env.Register("rand_seed", randSeed, "seeds the random number generator with Int $1")
+	env.Register("uuid", uuid, "returns a random version 4 UUID")
+	env.Register("bytes", bytes_, "returns Bytes made from String $1 or from a list of Int $1")
+	env.Register("bstring", bstring, "returns Bytes $1 as a String")
+	env.Register("blist", blist, "returns Bytes $1 as a list of Int")
+	env.Register("blen", blen, "returns the amount of bytes in Bytes or String $1")
+	env.Register("hex", hex_, "encodes Bytes or String $1 as hexadecimal")
+	env.Register("unhex", unhex, "decodes hexadecimal String $1 to Bytes")
+	env.Register("base64", base64_, "encodes Bytes or String $1 as base64, or as URL safe base64 if $2 is url")
+	env.Register("unbase64", unbase64, "decodes base64 String $1 to Bytes, or URL safe base64 if $2 is url")
+	env.Register("base32", base32_, "encodes Bytes or String $1 as base32")
+	env.Register("unbase32", unbase32, "decodes base32 String $1 to Bytes")
+	env.Register("sha256", digest("sha256"), "returns the SHA-256 hash of Bytes or String $1 as Bytes")
+	env.Register("sha1", digest("sha1"), "returns the SHA-1 hash of Bytes or String $1 as Bytes")
+	env.Register("md5", digest("md5"), "returns the MD5 hash of Bytes or String $1 as Bytes")
+	env.Register("crc32", crc32_, "returns the IEEE CRC-32 checksum of Bytes or String $1 as an Int")
+	env.Register("hmac", hmac_, "returns the HMAC of message $3 with key $2 using hash $1, one of sha256, sha1 or md5, as Bytes")
 
 	env.Register("p", p, "print debug output")
 	env.Register("print", print_, "print to the environnment's current writer with interpolation")
 	env.Register("write", write, "write to the environnment's current writer")
+	env.Register("eprint", eprint, "print to the environnment's current error writer with interpolation")
+	env.Register("capture", capture, "runs the block $1 and returns everything it printed as a String")
+	env.Register("redirect", redirect, "runs the block $2 with output redirected to $1, which is out, err, null or a buffer")
+	env.Register("buffer", buffer, "creates a new output buffer for use with redirect, with the arguments as initial contents")
+	env.Register("read", read, "reads at most $1 bytes from the environment's current reader as a String")
+	env.Register("readline", readline, "reads a line from the environment's current reader, or returns nil at the end of input")
+	env.Register("readall", readall, "reads all remaining input from the environment's current reader")
+	env.Register("eof", eof, "returns true if there is no more input available")
+	env.Register("lines", lines, "calls the block for each line of input, with the line in $1 and in the optionally named variable, and an optional loop label")
 	env.Register("to", to, "define a procedure")
 	env.Register("do", do, "execute a command $1 with arguments in $2 as array")
 	env.Register("ret", ret, "return from a procedure")
 	env.Register("return", ret, "return from a procedure")
-	env.Register("break", break_, "return from a block")
+	env.Register("break", break_, "breaks out of the current loop, or of the loop labeled $1")
+	env.Register("try", try, "evaluates block $1, with optional catch ?name? ?kind? {handler} clauses for failures and a finally {cleanup} block that always runs")
+	env.Register("defer", defer_, "runs block $1 when the procedure that calls defer exits, in reverse order of deferral")
+	env.Register("continue", continue_, "skips to the next iteration of the current loop, or of the loop labeled $1")
+	env.Register("exit", exit, "stops the script with exit code $1, or 0 if not given")
 	env.Register("val", val, "gets the value of a value")
 	env.Register("let", let, "creates a new vavariable with given value")
 	env.Register("set", set, "sets an existing variable")
@@ -850,7 +1655,11 @@ func (env *Environment) RegisterBuiltins() {
 	env.Register("help", help, "get help for a procedure")
 	env.Register("explain", explain, "set the help for a procedure")
 	env.Register("expand", expand, "interpolate strings from environment")
-	env.Register("fail", fail, "fail execution of a procedure")
+	env.Register("fail", fail, "fail execution of a procedure with message $1, or with kind $1, message $2 and payload $3 if $1 is a type")
+	env.Register("ekind", ekind, "returns the kind of error $1 as a type")
+	env.Register("epayload", epayload, "returns the payload of error $1")
+	env.Register("emessage", emessage, "returns the message of error $1")
+	env.Register("ecause", ecause, "returns the error that caused error $1, or nil")
 	env.Register("rescue", rescue, "call $1 as the error handler on failure")
 	env.Register("if", if_, "if runs $1 if $0 is true, otherwise runs $2")
 	env.Register("isnil", isnil, "returns true if $1 is nil, false if not")
@@ -865,5 +1674,6 @@ func (env *Environment) RegisterBuiltins() {
 // This function registers builtins that make Tgtl turing complete
 // Not to be used in situations where this is undesirable.
 func (env *Environment) RegisterTuringCompleteBuiltins() {
-	env.Register("while", while, "executes $2 while $1 returns true")
+	env.unbounded = true
+	env.Register("while", while, "executes $2 while $1 returns true, with optional loop label $3")
 }
@@ -0,0 +1,480 @@
+package tgtl
+
+import "testing"
+import "strings"
+import "os"
+
+type sTestCase struct {
+	script      string
+	expected    string
+	expectError bool
+}
+
+func newTestEnvironment() (*Environment, *strings.Builder) {
+	out := &strings.Builder{}
+	env := &Environment{Out: out}
+	env.Push()
+	env.RegisterBuiltins()
+	env.RegisterTuringCompleteBuiltins()
+	env.RegisterTaskBuiltins()
+	return env, out
+}
+
+func (tc *sTestCase) Run(t *testing.T) {
+	t.Logf("Test case script: %s", tc.script)
+	parsed, perr := Parse(tc.script + "\n")
+	if perr != nil {
+		t.Errorf("error: unexpected parse error: %v", perr)
+		return
+	}
+	env, out := newTestEnvironment()
+	defer env.Close()
+	_, eff := parsed.Eval(env)
+	err, isError := eff.(*Error)
+	if tc.expectError {
+		if !isError || err == nil {
+			t.Errorf("error: expected evaluation error")
+		}
+		return
+	}
+	if isError && err != nil {
+		t.Errorf("error: unexpected evaluation error: %v", err)
+		return
+	}
+	if out.String() != tc.expected {
+		t.Errorf("error: output not as expected: %q <-> %q", out.String(), tc.expected)
+	}
+}
+
+func runTestCases(t *testing.T, tcs []sTestCase) {
+	for i, tc := range tcs {
+		t.Logf("Case: %d", i+1)
+		tc.Run(t)
+	}
+}
+
+func TestMapBuiltins(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`let m [map a 1 b 2]; mdel $m a; print "$1" [mkeys $m]`,
+			"[list b]", false},
+		sTestCase{`let m [map a 1]; print "$1 $2" [mhas $m a] [mhas $m b]`,
+			"true false", false},
+		sTestCase{`print "$1" [mlen [map a 1 b nil]]`, "2", false},
+		sTestCase{`print "$1" [mvalues [map c 3 a 1 b 2]]`,
+			"[list 1 2 3]", false},
+		sTestCase{`print "$1" [mpairs [map b 2 a 1]]`,
+			"[list [list a 1] [list b 2]]", false},
+		sTestCase{`print "$1" [mpairs [mmerge [map a 1 b 2] [map b 3 c 4]]]`,
+			"[list [list a 1] [list b 3] [list c 4]]", false},
+		sTestCase{`meach [map c 3 a 1 b 2] k v { print "$k=$v;" } sorted`,
+			"a=1;b=2;c=3;", false},
+		sTestCase{`meach [map a 1] k v { print "$k" } shuffled`, "", true},
+	})
+}
+
+func TestDictBuiltins(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`print "$1" [dict z 1 a 2 m 3]`, "[dict z 1 a 2 m 3]", false},
+		sTestCase{`let d [dict 1 a "1" b]; print "$1 $2" [mget $d 1] [mget $d "1"]`,
+			"a b", false},
+		sTestCase{`let d [dict z 1 a 2]; mset $d b 3; mdel $d z; print "$1" [mkeys $d]`,
+			"[list a b]", false},
+		sTestCase{`meach [dict z 1 a 2] k v { print "$k=$v;" }`, "z=1;a=2;", false},
+		sTestCase{`print "$1" [mpairs [dict b 2 a 1]]`,
+			"[list [list b 2] [list a 1]]", false},
+		sTestCase{`print "$1" [mmerge [map a 1] [dict b 2]]`, "[dict a 1 b 2]", false},
+		sTestCase{`print "$1" [mlen [dict true 1 false 2]]`, "2", false},
+		sTestCase{`let d [dict a 1]; mset $d "a" 2; print "$1 $2 $3" [mlen $d] [mget $d a] [mkeys $d]`,
+			"1 2 [list a]", false},
+		sTestCase{`dict [list] 1`, "", true},
+	})
+}
+
+func TestEqualityBuiltins(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`print "$1" [eq [list 1 [map a b]] [list 1 [map a b]]]`, "true", false},
+		sTestCase{`print "$1" [eq [list 1 2] [list 1 3]]`, "false", false},
+		sTestCase{`print "$1 $2" [eq 1 "1"] [seq 1 "1"]`, "false true", false},
+		sTestCase{`print "$1" [eq [dict a 1 b 2] [dict b 2 a 1]]`, "true", false},
+		sTestCase{`print "$1 $2 $3" [cmp 2 10] [cmp "b" "a"] [cmp a a]`, "-1 1 0", false},
+		sTestCase{`print "$1" [cmp [list 1 2] [list 1 2 0]]`, "-1", false},
+		sTestCase{`print "$1" [eq [hash [map a 1 b 2]] [hash [map b 2 a 1]]]`, "true", false},
+	})
+}
+
+func TestCopyAndFreezeBuiltins(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`let l [list 1 2]; let f [freeze $l]; lset $l 0 9; print "$1 $2" $f [frozen $f]`,
+			"[list 1 2] true", false},
+		sTestCase{`let f [freeze [list 1 2]]; lset $f 0 9`, "", true},
+		sTestCase{`let f [freeze [map a [list 1]]]; lset [mget $f a] 0 9`, "", true},
+		sTestCase{`let f [freeze [dict a 1]]; mdel $f a`, "", true},
+		sTestCase{`let f [freeze [map a 1]]; let c [copy $f]; mset $c a 2; print "$1 $2" [mget $f a] [mget $c a]`,
+			"1 2", false},
+		sTestCase{`let l [list [list 1]]; let c [deepcopy $l]; lset [lget $c 0] 0 2; print "$1" $l`,
+			"[list [list 1]]", false},
+		sTestCase{`let l [list 1]; let a [ladd $l 2]; let b [ladd $l 3]; print "$1 $2" $a $b`,
+			"[list 1 2] [list 1 3]", false},
+		sTestCase{`print "$1" [eq [freeze [list 1]] [list 1]]`, "true", false},
+		sTestCase{`to f l { lset $l 0 9; print "$1 " $l }; let a [list 1 2]; f $a; print "$1" $a`,
+			"[list 9 2] [list 1 2]", false},
+		sTestCase{`to f { mset $1 a 2; mdel $1 b }; let m [map a 1 b 2]; f $m; print "$1" [mget $m a]`,
+			"1", false},
+		sTestCase{`let a [list [list 1]]; let b $a; lset [lget $b 0] 0 2; print "$1" $a`,
+			"[list [list 1]]", false},
+		sTestCase{`let m [map]; mset $m self $m; to f x { nop }; f $m; print "ok"`,
+			"ok", false},
+		sTestCase{`let l [list 1]; lset $l 0 $l; let c [deepcopy $l]; let f [freeze $l]; lset $c 0 2; print "$1" [llen [lget $l 0]]`,
+			"1", false},
+		sTestCase{`let d [dict]; mset $d self $d; let f [freeze $d]; print "$1" [frozen [mget [mget $f self] self]]`,
+			"true", false},
+	})
+}
+
+func TestJSONBuiltins(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`print "$1" [json_encode [map b [list 1 $true] a "x"]]`,
+			`{"a":"x","b":[1,true]}`, false},
+		sTestCase{`print "$1" [json_encode [dict b 1 a 2] sorted]`, `{"a":2,"b":1}`, false},
+		sTestCase{`print "$1" [json_encode [list] pretty]`, `[]`, false},
+		sTestCase{`print "$1" [json_decode "{\"b\":1,\"a\":2}" ordered]`, `[dict b 1 a 2]`, false},
+		sTestCase{`json_decode "[1,"`, "", true},
+	})
+}
+
+func TestCSVBuiltins(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{"print \"$1\" [csv_read \"a,b\\n1,\\\"x,y\\\"\\n\"]",
+			"[list [list a b] [list 1 x,y]]", false},
+		sTestCase{"csv_read \"a;b\\n1;2\\n3;4\\n\" [map delimiter \";\" header $true] row { print \"$1 \" [mget $row b] }",
+			"2 4 ", false},
+		sTestCase{"print \"$1\" [csv_write [list [list a \"b c\"] [list 1 \"x,y\"]]]",
+			"a,b c\n1,\"x,y\"\n", false},
+		sTestCase{"csv_write [list [dict b 1 a 2] [dict a 3]] [map header $true out $true]",
+			"b,a\n1,2\n,3\n", false},
+		sTestCase{"csv_read \"a\\n\\\"b\\n\"", "", true},
+		sTestCase{"csv_read $nope", "", true},
+	})
+}
+
+func TestInputBuiltins(t *testing.T) {
+	script := `
+print "[$1]" [read 3]
+print "[$1]" [readline]
+print "[$1]" [readline]
+lines line { print "<$line>" }
+print "[$1 $2]" [eof] [readline]
+`
+	parsed, perr := Parse(script)
+	if perr != nil {
+		t.Fatalf("error: unexpected parse error: %v", perr)
+	}
+	input := "abcdef\r\nline2\nx\ny"
+	// A funcReader cannot be compared with ==.
+	for _, in := range []Reader{strings.NewReader(input), funcReader(strings.NewReader(input).Read)} {
+		env, out := newTestEnvironment()
+		env.In = in
+		env.Top().In = env.In
+		_, eff := parsed.Eval(env)
+		if eff != nil {
+			t.Fatalf("error: unexpected effect: %v", eff)
+		}
+		expect := "[abc][def][line2]<x><y>[true !nil]"
+		if out.String() != expect {
+			t.Errorf("error: output not as expected: %q <-> %q", out.String(), expect)
+		}
+	}
+}
+
+// funcReader is a reader that calls a function.
+type funcReader func(p []byte) (int, error)
+
+func (fr funcReader) Read(p []byte) (int, error) {
+	return fr(p)
+}
+
+func TestOutputBuiltins(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`let s [capture { print "a"; print "b$1" 1 }]; print "<$s>"`, "<ab1>", false},
+		sTestCase{`to greet name { print "hi $name" }; print "<$1>" [capture { greet you }]`,
+			"<hi you>", false},
+		sTestCase{`let b [buffer "x"]; redirect $b { print "y" }; print "$b"`, "xy", false},
+		sTestCase{`redirect null { print "hidden" }; print "shown"`, "shown", false},
+		sTestCase{`redirect err { print "x" }`, "", true},
+		sTestCase{`capture { fail "oops" }`, "", true},
+		sTestCase{`repeat 3 { capture { print "x"; break }; print "y" }; print "z"`, "z", false},
+		sTestCase{`repeat 3 { capture { print "x"; continue }; print "y" }; print "z"`, "z", false},
+	})
+	env, out := newTestEnvironment()
+	errOut := &strings.Builder{}
+	env.Err = errOut
+	env.Top().Err = errOut
+	parsed, _ := Parse("eprint \"e$1\" 1; redirect err { print \"r\" }; print \"o\"\n")
+	parsed.Eval(env)
+	if errOut.String() != "e1r" || out.String() != "o" {
+		t.Errorf("error: output not as expected: %q %q", errOut.String(), out.String())
+	}
+}
+
+func TestPathBuiltins(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`print "$1" [path_join a/b "../c" "d.txt"]`, "a/c/d.txt", false},
+		sTestCase{`print "$1 $2 $3" [path_base "/a/b.tar.gz"] [path_dir "/a/b.tar.gz"] [path_ext "/a/b.tar.gz"]`,
+			"b.tar.gz /a .gz", false},
+		sTestCase{`print "$1" [path_clean "a//b/./c/.."]`, "a/b", false},
+		sTestCase{`print "$1 $2 $3" [path_rel /a/b /a/c/d] [path_rel a a] [path_rel a/b a]`,
+			"../c/d . ..", false},
+		sTestCase{`path_rel /a b`, "", true},
+		sTestCase{`print "$1" [path_match "*.go" "main.go"]`, "true", false},
+		sTestCase{`print "$1" [glob "*.go" [list "z.go" "b.txt" "a.go"]]`, "[list a.go z.go]", false},
+		sTestCase{`glob "*.go"`, "", true},
+		sTestCase{`path_join a $unset`, "", true},
+		sTestCase{`glob "*.go" [list "a.go" $unset]`, "", true},
+		sTestCase{`glob $unset`, "", true},
+	})
+}
+
+func TestExitBuiltin(t *testing.T) {
+	env, out := newTestEnvironment()
+	parsed, perr := Parse(`to f { exit 3 }
+rescue { print "rescued" }
+print "before "
+f
+print "after"
+`)
+	if perr != nil {
+		t.Fatalf("error: unexpected parse error: %v", perr)
+	}
+	_, eff := parsed.Eval(env)
+	exit, ok := eff.(Exit)
+	if !ok || exit.Code != 3 {
+		t.Errorf("error: expected exit effect with code 3: %v", eff)
+	}
+	if out.String() != "before " {
+		t.Errorf("error: output not as expected: %q", out.String())
+	}
+}
+
+func TestEnvBuiltins(t *testing.T) {
+	env, out := newTestEnvironment()
+	env.RegisterEnvBuiltins()
+	defer os.Unsetenv("TGTL_TEST_VAR")
+	parsed, perr := Parse(`setenv TGTL_TEST_VAR "hello"
+print "$1 " [getenv TGTL_TEST_VAR]
+print "$1 " [mget [environ] TGTL_TEST_VAR]
+unsetenv TGTL_TEST_VAR
+print "$1" [getenv TGTL_TEST_VAR]
+`)
+	if perr != nil {
+		t.Fatalf("error: unexpected parse error: %v", perr)
+	}
+	_, eff := parsed.Eval(env)
+	if eff != nil {
+		t.Fatalf("error: unexpected effect: %v", eff)
+	}
+	if out.String() != "hello hello !nil" {
+		t.Errorf("error: output not as expected: %q", out.String())
+	}
+}
+
+func TestBytesBuiltins(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`print "$1" [bytes "hi"]`, "[unhex 6869]", false},
+		sTestCase{`print "$1" [bstring [bytes [list 104 105]]]`, "hi", false},
+		sTestCase{`print "$1 $2" [blist [bytes "hi"]] [blen "é"]`, "[list 104 105] 2", false},
+		sTestCase{`print "$1" [eq [unhex 6869] [bytes "hi"]]`, "true", false},
+		sTestCase{`print "$1" [hex [sha256 "abc"]]`,
+			"ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", false},
+		sTestCase{`print "$1" [hex [sha1 "abc"]]`,
+			"a9993e364706816aba3e25717850c26c9cd0d89d", false},
+		sTestCase{`print "$1" [hex [md5 "abc"]]`,
+			"900150983cd24fb0d6963f7d28e17f72", false},
+		sTestCase{`print "$1" [crc32 "abc"]`, "891568578", false},
+		sTestCase{`print "$1" [hex [hmac sha256 "key" "The quick brown fox jumps over the lazy dog"]]`,
+			"f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8", false},
+		sTestCase{`print "$1 $2" [base64 "hi?>"] [base64 "hi?>" url]`, "aGk/Pg== aGk_Pg==", false},
+		sTestCase{`print "$1" [bstring [unbase64 "aGk_Pg==" url]]`, "hi?>", false},
+		sTestCase{`print "$1 $2" [base32 "hi"] [bstring [unbase32 "NBUQ===="]]`, "NBUQ==== hi", false},
+		sTestCase{`bytes [list 256]`, "", true},
+		sTestCase{`unhex "zz"`, "", true},
+		sTestCase{`hmac sha512 "key" "msg"`, "", true},
+	})
+}
+
+func TestTryBuiltin(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`print "$1" [try { fail "boom" } catch err { sadd "caught " $err }]`,
+			"caught boom", false},
+		sTestCase{`try { print "body " } catch { print "not caught " } finally { print "finally" }`,
+			"body finally", false},
+		sTestCase{`try { fail "boom" } catch { print "$1 " } finally { print "finally" }`,
+			"boom finally", false},
+		sTestCase{`try { try { fail "inner" } catch e OtherError { print "wrong" } finally { print "inner finally " } } catch e UserError { print "outer $1" }`,
+			"inner finally outer inner", false},
+		sTestCase{`try { try { fail "again" } catch e { print "rethrow " ; fail $e } } catch e { print "$1" $e }`,
+			"rethrow again", false},
+		sTestCase{`to f { try { return 1 } finally { print "cleanup " }; print "not reached" }; print "$1" [f]`,
+			"cleanup 1", false},
+		sTestCase{`leach [list 1 2] i v { try { break 5; print "not reached" } finally { print "finally" } }`,
+			"finally", false},
+		sTestCase{`try { print "ok" } finally { fail "cleanup failed" }`, "", true},
+		sTestCase{`try { fail "uncaught" } finally { print "finally" }`, "", true},
+		sTestCase{`try { print "x" } catch`, "", true},
+	})
+}
+
+func TestDeferBuiltin(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`to f { defer { print "1 " }; defer { print "2 " }; print "body " }; f`,
+			"body 2 1 ", false},
+		sTestCase{`to f { defer { print "cleanup " }; return 5; print "not reached" }; print "$1" [f]`,
+			"cleanup 5", false},
+		sTestCase{`to f { defer { print "cleanup " }; fail "boom" }; try { f } catch e { print "$1" $e }`,
+			"cleanup boom", false},
+		sTestCase{`to f { if $true { defer { print "nested " } }; print "body " }; f`,
+			"body nested ", false},
+		sTestCase{`to f { defer { fail "cleanup failed" }; print "body " }; try { f } catch e { print "$1" $e }`,
+			"body cleanup failed", false},
+		sTestCase{`to f { defer { print "outer " }; to g { defer { print "inner " } }; g; print "body " }; f`,
+			"inner body outer ", false},
+		sTestCase{`to f { defer { exit 3 }; print "body " }; f; print "after"`, "body ", false},
+		sTestCase{`to f { defer { exit 3 }; fail "boom" }; try { f } catch { print "caught" }; print "after"`, "", false},
+		sTestCase{`to f { defer { return 2 }; iadd 1 0 }; print "$1" [f]`, "1", false},
+		sTestCase{`defer { print "nope" }`, "", true},
+	})
+}
+
+func TestFramesRecoverAfterOverflow(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`to f { f; nop }; try { f } catch { print "caught " }; to g { print "still works" }; g`,
+			"caught still works", false},
+	})
+}
+
+func TestLoopFlow(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`leach [list 1 2 3 4] i v { if [eq $v 2] { continue }; print "$v " }`,
+			"1 3 4 ", false},
+		sTestCase{`leach [list 1 2 3 4] i v { if [eq $v 3] { break }; print "$v " }`,
+			"1 2 ", false},
+		sTestCase{`let i 0; while { ilt $i 5 } { set i [iadd $i 1]; if [eq $i 2] { continue }; if [eq $i 4] { break }; print "$i " }`,
+			"1 3 ", false},
+		sTestCase{`meach [map a 1 b 2 c 3] k v { if [eq $k "b"] { continue }; print "$k " } sorted`,
+			"a c ", false},
+		sTestCase{`leach [list 1 2] i a { leach [list "x" "y" "z"] j b { if [eq $b "y"] { continue outer }; print "${a}/${b} " } }  outer`,
+			"1/x 2/x ", false},
+		sTestCase{`leach [list 1 2] i a { leach [list "x" "y" "z"] j b { if [eq $b "y"] { break outer }; print "${a}/${b} " } } outer; print "done"`,
+			"1/x done", false},
+		sTestCase{`meach [map a 1 b 2] k v { leach [list 1 2] i w { break outer } ; print "not reached" } unsorted outer; print "done"`,
+			"done", false},
+		sTestCase{`print "$1" [csv_read "a\nb\nc\n" row { if [eq [lget $row 0] "b"] { break }; print "$row " }]`,
+			"[list a] 2", false},
+		sTestCase{`to f { leach [list 1 2 3] i v { if [eq $v 2] { return $v } } }; print "$1" [f]`,
+			"2", false},
+		sTestCase{`continue nowhere`, "", true},
+		sTestCase{`break`, "", true},
+		sTestCase{`continue`, "", true},
+		sTestCase{`to f { break outer }; leach [list 1] i v { f } outer`, "", true},
+		sTestCase{`to f { continue outer }; leach [list 1] i v { f } outer`, "", true},
+		sTestCase{`leach [list 1] i v { print "x" } [list]`, "", true},
+	})
+}
+
+func TestBoundedLoops(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`repeat 3 { print "$1 " }`, "0 1 2 ", false},
+		sTestCase{`repeat 0 { print "never" }`, "", false},
+		sTestCase{`for i 1 3 { print "$i " }`, "1 2 3 ", false},
+		sTestCase{`for i 10 1 -4 { print "$i " }`, "10 6 2 ", false},
+		sTestCase{`for i 1 10 { if [eq $i 3] { break }; print "$i " }`, "1 2 ", false},
+		sTestCase{`for i 1 2 { repeat 5 { if [eq $1 1] { continue outer }; print "${i}/${1} " } } outer`, "1/0 2/0 ", false},
+		sTestCase{`print "$1 $2 $3" [range 3] [range 2 4] [range 5 0 -2]`,
+			"[list 0 1 2] [list 2 3 4] [list 5 3 1]", false},
+		sTestCase{`print "$1" [range 0]`, "[list]", false},
+		sTestCase{`for i 1 3 0 { print "never" }`, "", true},
+		sTestCase{`repeat 1000000 { print "never" }`, "", true},
+		sTestCase{`range 1000001`, "", true},
+	})
+}
+
+func TestBoundedLoopsLimit(t *testing.T) {
+	out := &strings.Builder{}
+	env := &Environment{Out: out, LoopsMax: 3}
+	env.Push()
+	env.RegisterBuiltins()
+	parsed, _ := Parse("repeat 3 { print \"ok \" }\nrepeat 4 { print \"not ok\" }\n")
+	_, eff := parsed.Eval(env)
+	if err, ok := eff.(*Error); !ok || !IsKind(err, QuotaExceeded) {
+		t.Errorf("error: expected quota exceeded: %v", eff)
+	}
+	if out.String() != "ok ok ok " {
+		t.Errorf("error: output not as expected: %q", out.String())
+	}
+	if env.Lookup("while") != nil {
+		t.Errorf("error: while should not be registered in the restricted profile")
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`to count n acc { if [eq $n 0] { return $acc }; count [isub $n 1] [iadd $acc 1] }; print "$1" [count 5000 0]`,
+			"5000", false},
+		sTestCase{`to even n { if [eq $n 0] { return $true }; odd [isub $n 1] }; to odd n { if [eq $n 0] { return $false }; even [isub $n 1] }; print "$1" [even 1001]`,
+			"false", false},
+		sTestCase{`to down n { if [eq $n 0] { return "done" }; defer { nop }; down [isub $n 1] }; down 200`,
+			"", true},
+		sTestCase{`to deep n { if [eq $n 0] { return 0 }; iadd 1 [deep [isub $n 1]] }; deep 200`,
+			"", true},
+		sTestCase{`to count n acc { if [eq $n 0] { return $acc } else { count [isub $n 1] [iadd $acc 1] } }; print "$1" [count 5000 0]`,
+			"5000", false},
+		sTestCase{`to count n acc { if [eq $n 0] { return $acc }; return [count [isub $n 1] [iadd $acc 1]] }; print "$1" [count 5000 0]`,
+			"5000", false},
+		sTestCase{`to down n { switch $n 0 { return "done" } default { down [isub $n 1] } }; print "$1" [down 5000]`,
+			"done", false},
+		sTestCase{`to down n { if [eq $n 0] { return "done" } else { defer { nop }; down [isub $n 1] } }; down 200`,
+			"", true},
+		sTestCase{`to g v { print "$1" $v }; to f { leach [list 1 2] i v { if $true { g $v } }; print "x" }; f`,
+			"12x", false},
+	})
+}
+
+func TestTailCallsRestricted(t *testing.T) {
+	script := "to count n acc { if [eq $n 0] { return $acc } else { count [isub $n 1] [iadd $acc 1] } }\nprint \"$1\" [count 200 0]\n"
+	parsed, _ := Parse(script)
+	out := &strings.Builder{}
+	env := &Environment{Out: out, LoopsMax: 100}
+	env.Push()
+	env.RegisterBuiltins()
+	if _, eff := parsed.Eval(env); eff == nil || eff.Flow() != FailFlow {
+		t.Errorf("error: expected tail calls to be limited, got: %v", eff)
+	}
+	// Defining while does not lift the restriction.
+	for _, script := range []string{"to while { nop }\nto f { f }\nf\n", "let while 1\nto f { f }\nf\n"} {
+		shadow, _ := Parse(script)
+		if _, eff := shadow.Eval(env); eff == nil || eff.Flow() != FailFlow {
+			t.Errorf("error: expected tail calls to be limited, got: %v", eff)
+		}
+	}
+	env.RegisterTuringCompleteBuiltins()
+	if _, eff := parsed.Eval(env); eff != nil {
+		t.Fatalf("error: unexpected effect: %v", eff)
+	}
+	if out.String() != "200" {
+		t.Errorf("error: output not as expected: %q", out.String())
+	}
+}
+
+func TestFramesMax(t *testing.T) {
+	out := &strings.Builder{}
+	env := &Environment{Out: out, FramesMax: 1000}
+	env.Push()
+	env.RegisterBuiltins()
+	parsed, _ := Parse("to deep n { if [eq $n 0] { return 0 }; iadd 1 [deep [isub $n 1]] }\nprint \"$1\" [deep 100]\n")
+	_, eff := parsed.Eval(env)
+	if eff != nil {
+		t.Fatalf("error: unexpected effect: %v", eff)
+	}
+	if out.String() != "100" {
+		t.Errorf("error: output not as expected: %q", out.String())
+	}
+}
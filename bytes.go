@@ -0,0 +1,279 @@
+package tgtl
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	gohash "hash"
+	"hash/crc32"
+)
+
+// Bytes is a string of bytes, for binary data.
+type Bytes []byte
+
+// String returns the bytes as an unhex command,
+// so they evaluate back to the same bytes.
+func (bv Bytes) String() string {
+	return "[unhex " + hex.EncodeToString(bv) + "]"
+}
+
+func (bv Bytes) Eval(env *Environment, args ...Value) (Value, Effect) {
+	return bv, nil
+}
+
+func (Bytes) Type() Type { return Type("Bytes") }
+
+func (from Bytes) Convert(to interface{}) *Error {
+	switch toPtr := to.(type) {
+	case *string:
+		(*toPtr) = string(from)
+	case *String:
+		(*toPtr) = String(from)
+	case *[]byte:
+		(*toPtr) = from
+	case *Bytes:
+		(*toPtr) = from
+	case *List:
+		list := List{}
+		for _, b := range from {
+			list = append(list, Int(b))
+		}
+		(*toPtr) = list
+	case *bool:
+		(*toPtr) = (len(from) > 0)
+	case *Bool:
+		(*toPtr) = (len(from) > 0)
+	case *Value:
+		(*toPtr) = from
+	default:
+		return ErrorFromString("Cannot convert Bytes value")
+	}
+	return nil
+}
+
+func (bv Bytes) Equal(other Value) bool {
+	ov, ok := other.(Bytes)
+	return ok && bytes.Equal(bv, ov)
+}
+
+func (bv Bytes) Compare(other Value) int {
+	ov, _ := other.(Bytes)
+	return bytes.Compare(bv, ov)
+}
+
+func (bv Bytes) Hash() uint64 {
+	return hashTyped(bv.Type(), string(bv))
+}
+
+// ListBytes converts a list of integers from 0 to 255 to Bytes.
+func ListBytes(list List) (Bytes, *Error) {
+	res := Bytes{}
+	for i, val := range list {
+		var b Int
+		err := Convert(val, &b)
+		if err != nil {
+			return nil, err
+		}
+		if b < 0 || b > 255 {
+			return nil, ErrorFromString("byte out of range at index " + Itoa(i) + ": " + b.String())
+		}
+		res = append(res, byte(b))
+	}
+	return res, nil
+}
+
+// ToBytes converts a Bytes, String or Word value to Bytes.
+func ToBytes(val Value) (Bytes, *Error) {
+	switch v := val.(type) {
+	case Bytes:
+		return v, nil
+	case String:
+		return Bytes(v), nil
+	case Word:
+		return Bytes(v), nil
+	}
+	return nil, ErrorFromString("Cannot convert to Bytes: " + TypeOf(val).String())
+}
+
+// bytesArgs converts the arguments to Bytes.
+func bytesArgs(name string, args []Value, amount int) ([]Bytes, *Error) {
+	if len(args) < amount {
+		return nil, ErrorWithKind(ArgumentError, name+": expected "+Itoa(amount)+" arguments")
+	}
+	res := []Bytes{}
+	for _, arg := range args[0:amount] {
+		b, err := ToBytes(arg)
+		if err != nil {
+			return nil, ErrorWithKind(TypeError, name+": "+err.Error())
+		}
+		res = append(res, b)
+	}
+	return res, nil
+}
+
+func bytes_(env *Environment, args ...Value) (Value, Effect) {
+	if len(args) < 1 {
+		return env.FailKind(ArgumentError, "bytes: expected 1 argument")
+	}
+	if list, ok := args[0].(List); ok {
+		res, err := ListBytes(list)
+		if err != nil {
+			return env.Fail(err)
+		}
+		return res, nil
+	}
+	res, err := ToBytes(args[0])
+	if err != nil {
+		return env.Fail(err)
+	}
+	return append(Bytes{}, res...), nil
+}
+
+func bstring(env *Environment, args ...Value) (Value, Effect) {
+	b, err := bytesArgs("bstring", args, 1)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return String(b[0]), nil
+}
+
+func blist(env *Environment, args ...Value) (Value, Effect) {
+	b, err := bytesArgs("blist", args, 1)
+	if err != nil {
+		return env.Fail(err)
+	}
+	var list List
+	b[0].Convert(&list)
+	return list, nil
+}
+
+func blen(env *Environment, args ...Value) (Value, Effect) {
+	b, err := bytesArgs("blen", args, 1)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return Int(len(b[0])), nil
+}
+
+func hex_(env *Environment, args ...Value) (Value, Effect) {
+	b, err := bytesArgs("hex", args, 1)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return String(hex.EncodeToString(b[0])), nil
+}
+
+func unhex(env *Environment, args ...Value) (Value, Effect) {
+	var text string
+	err := Args(args, &text)
+	if err != nil {
+		return env.Fail(err)
+	}
+	res, herr := hex.DecodeString(text)
+	if herr != nil {
+		return env.Fail(ErrorFromError(herr))
+	}
+	return Bytes(res), nil
+}
+
+// base64Encoding returns the standard encoding,
+// or the URL encoding if the word url is the second argument.
+func base64Encoding(args []Value) *base64.Encoding {
+	if len(args) > 1 && args[1] != nil && args[1].String() == "url" {
+		return base64.URLEncoding
+	}
+	return base64.StdEncoding
+}
+
+func base64_(env *Environment, args ...Value) (Value, Effect) {
+	b, err := bytesArgs("base64", args, 1)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return String(base64Encoding(args).EncodeToString(b[0])), nil
+}
+
+func unbase64(env *Environment, args ...Value) (Value, Effect) {
+	var text string
+	err := Args(args, &text)
+	if err != nil {
+		return env.Fail(err)
+	}
+	res, berr := base64Encoding(args).DecodeString(text)
+	if berr != nil {
+		return env.Fail(ErrorFromError(berr))
+	}
+	return Bytes(res), nil
+}
+
+func base32_(env *Environment, args ...Value) (Value, Effect) {
+	b, err := bytesArgs("base32", args, 1)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return String(base32.StdEncoding.EncodeToString(b[0])), nil
+}
+
+func unbase32(env *Environment, args ...Value) (Value, Effect) {
+	var text string
+	err := Args(args, &text)
+	if err != nil {
+		return env.Fail(err)
+	}
+	res, berr := base32.StdEncoding.DecodeString(text)
+	if berr != nil {
+		return env.Fail(ErrorFromError(berr))
+	}
+	return Bytes(res), nil
+}
+
+// hashes are the hash functions that can be used with hmac.
+var hashes = map[string]func() gohash.Hash{
+	"sha256": sha256.New,
+	"sha1":   sha1.New,
+	"md5":    md5.New,
+}
+
+func digest(name string) Proc {
+	return func(env *Environment, args ...Value) (Value, Effect) {
+		b, err := bytesArgs(name, args, 1)
+		if err != nil {
+			return env.Fail(err)
+		}
+		h := hashes[name]()
+		h.Write(b[0])
+		return Bytes(h.Sum(nil)), nil
+	}
+}
+
+func crc32_(env *Environment, args ...Value) (Value, Effect) {
+	b, err := bytesArgs("crc32", args, 1)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return Int(crc32.ChecksumIEEE(b[0])), nil
+}
+
+func hmac_(env *Environment, args ...Value) (Value, Effect) {
+	var name string
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	newHash, ok := hashes[name]
+	if !ok {
+		return env.FailString("hmac: unknown hash ${1}", String(name))
+	}
+	b, err := bytesArgs("hmac", args[1:], 2)
+	if err != nil {
+		return env.Fail(err)
+	}
+	mac := hmac.New(newHash, b[0])
+	mac.Write(b[1])
+	return Bytes(mac.Sum(nil)), nil
+}
@@ -11,15 +11,20 @@ import (
 import "github.com/beoran/tgtl"
 import "github.com/peterh/liner"
 
-func runLine(env *tgtl.Environment, in string) *tgtl.Error {
+// runLine runs a line of input. It returns the exit effect
+// if the line called exit.
+func runLine(env *tgtl.Environment, in string) (*tgtl.Exit, *tgtl.Error) {
 	parsed, err := tgtl.Parse(in)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if parsed == nil {
-		return tgtl.ErrorFromString("No parse results")
+		return nil, tgtl.ErrorFromString("No parse results")
 	}
 	val, eff := parsed.Eval(env)
+	if exit, ok := eff.(tgtl.Exit); ok {
+		return &exit, nil
+	}
 	if val != nil {
 		env.Printi(">>${1}\n", val)
 	} else {
@@ -27,12 +32,14 @@ func runLine(env *tgtl.Environment, in string) *tgtl.Error {
 	}
 	err, ok := eff.(*tgtl.Error)
 	if ok {
-		return err
+		return nil, err
 	}
-	return nil
+	return nil, nil
 }
 
-func runLines(env *tgtl.Environment, line *liner.State) error {
+// runLines runs the interactive interpreter, and returns the
+// exit code.
+func runLines(env *tgtl.Environment, line *liner.State) int {
 	buf := ""
 	for {
 		if in, err := line.Prompt("> "); err == nil {
@@ -48,66 +55,103 @@ func runLines(env *tgtl.Environment, line *liner.State) error {
 				} else {
 					buf = in + "\n"
 				}
-				rerr := runLine(env, buf)
+				exit, rerr := runLine(env, buf)
 				buf = ""
 				if rerr != nil {
 					env.Printi("Error ${1}: \n", tgtl.String(rerr.Message))
 				}
+				if exit != nil {
+					line.AppendHistory(in)
+					return int(exit.Code)
+				}
 			}
 			line.AppendHistory(in)
 		} else if err == liner.ErrPromptAborted {
 			env.Printi("Aborted\n")
-			return nil
+			return 0
 		} else if err == io.EOF {
-			return nil
+			return 0
 		} else {
 			env.Printi("Error reading line: ${1}\n", tgtl.ErrorFromError(err))
 		}
 	}
-	return nil
+	return 0
 }
 
-func runFile(env *tgtl.Environment, name string) *tgtl.Error {
+// runFile runs the script in the named file with the given
+// arguments, and returns the exit code of the script.
+func runFile(env *tgtl.Environment, name string, scriptArgs []string) (int, *tgtl.Error) {
 	fin, err := os.Open(name)
 	if err != nil {
-		return tgtl.ErrorFromError(err)
+		return 1, tgtl.ErrorFromError(err)
 	}
 	defer fin.Close()
 	buf, err := ioutil.ReadAll(fin)
 	if err != nil {
-		return tgtl.ErrorFromError(err)
+		return 1, tgtl.ErrorFromError(err)
 	}
 	in := string(buf)
 
 	parsed, rerr := tgtl.Parse(in)
 	if rerr != nil {
-		return rerr
+		return 1, rerr
 	}
 	if parsed == nil {
-		return tgtl.ErrorFromString("Parse result is empty.")
-	}
-	args := tgtl.List{}
-	for _, a := range os.Args {
-		args = append(args, tgtl.String(a))
+		return 1, tgtl.ErrorFromString("Parse result is empty.")
 	}
+	// The script receives only it's own arguments in $1...,
+	// $argc and $argv, and it's own name in $SCRIPT.
+	env.Define("SCRIPT", tgtl.String(name), -1)
+	args := tgtl.StringList(scriptArgs...)
 	_, reff := parsed.Eval(env, args...)
+	if exit, ok := reff.(tgtl.Exit); ok {
+		return int(exit.Code), nil
+	}
 	rerr, ok := reff.(*tgtl.Error)
-	if ok {
-		return rerr
+	if ok && rerr != nil {
+		return 1, rerr
 	}
-	return nil
+	return 0, nil
 }
 
 func main() {
 	// console := muesli.NewStdConsole()
 	env := &tgtl.Environment{}
 	env.Out = os.Stdout
+	env.In = os.Stdin
+	env.Err = os.Stderr
 	env.Push()
 
 	env.RegisterBuiltins()
 	env.RegisterTuringCompleteBuiltins()
+	env.RegisterTaskBuiltins()
+	if wd, err := os.Getwd(); err == nil {
+		env.FS = tgtl.NewDirFS(wd)
+		env.RegisterFileBuiltins()
+	}
+	// Programs listed in TGTL_EXEC_ALLOW, separated like PATH,
+	// may be executed.
+	if allow := os.Getenv("TGTL_EXEC_ALLOW"); allow != "" {
+		env.ExecPolicy = &tgtl.ExecPolicy{
+			Allow:      filepath.SplitList(allow),
+			InheritEnv: true,
+		}
+		env.RegisterExecBuiltins()
+	}
+	env.RegisterEnvBuiltins()
+
+	// tgtl script args... runs the script with it's arguments.
+	if len(os.Args) > 1 {
+		name := os.Args[1]
+		code, rerr := runFile(env, name, os.Args[2:])
+		if rerr != nil {
+			sname := tgtl.String(name)
+			env.WriteErr(env.Interpolate("error in ${1}: ${2}\n", sname, rerr))
+		}
+		os.Exit(code)
+	}
+
 	line := liner.NewLiner()
-	defer line.Close()
 
 	line.SetCtrlCAborts(true)
 	home, _ := os.UserHomeDir()
@@ -118,22 +162,10 @@ func main() {
 		f.Close()
 	}
 
-	if len(os.Args) > 1 {
-		for i := 1; i < len(os.Args); i++ {
-			name := os.Args[i]
-			rerr := runFile(env, name)
-			if rerr != nil {
-				sname := tgtl.String(name)
-				env.Printi("error in ${1}: ${2}\n", sname,
-					rerr)
-			}
-		}
-		return
-	}
 	line.SetWordCompleter(func(line string, pos int) (head string, c []string, tail string) {
 		return tgtl.WordCompleter(*env, line, pos)
 	})
-	runLines(env, line)
+	code := runLines(env, line)
 
 	if f, err := os.Create(historyName); err != nil {
 		env.Printi("Error writing history file: ${1}\n", tgtl.ErrorFromError(err))
@@ -141,4 +173,6 @@ func main() {
 		line.WriteHistory(f)
 		f.Close()
 	}
+	line.Close()
+	os.Exit(code)
 }
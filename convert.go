@@ -126,6 +126,10 @@ func (from String) Convert(to interface{}) *Error {
 		(*toPtr) = ErrorFromString(from.String())
 	case *String:
 		(*toPtr) = from
+	case *[]byte:
+		(*toPtr) = []byte(from)
+	case *Bytes:
+		(*toPtr) = Bytes(from)
 	case *Value:
 		(*toPtr) = from
 	default:
@@ -178,6 +182,8 @@ func (from Map) Convert(to interface{}) *Error {
 		(*toPtr) = (len(from) > 0)
 	case *Map:
 		(*toPtr) = from
+	case *Mapper:
+		(*toPtr) = from
 	case *Value:
 		(*toPtr) = from
 	default:
@@ -194,6 +200,12 @@ func (from List) Convert(to interface{}) *Error {
 		(*toPtr) = (len(from) > 0)
 	case *List:
 		(*toPtr) = from
+	case *Bytes:
+		res, err := ListBytes(from)
+		if err != nil {
+			return err
+		}
+		(*toPtr) = res
 	case *Value:
 		(*toPtr) = from
 	default:
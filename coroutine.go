@@ -0,0 +1,354 @@
+package tgtl
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// coResult is the result of running a coroutine until it yields
+// or is done.
+type coResult struct {
+	val  Value
+	eff  Effect
+	done bool
+}
+
+// Coroutine is a block that can be suspended with yield and resumed
+// with resume or next. The block runs in it's own goroutine, in an
+// environment with copies of the variables of the environment that
+// created it, like a task. It only ever runs while the command that
+// resumed it waits for it.
+// Generators are coroutines that are used as lazy sequences.
+// Coroutines may be shared between tasks. They are resumed by one
+// task at a time, the other tasks wait until it is their turn.
+type Coroutine struct {
+	Block
+	env    *Environment
+	args   []Value
+	resume chan Value
+	yield  chan coResult
+	// mu is locked while the coroutine is resumed or stopped.
+	mu      sync.Mutex
+	started bool
+	// done is 1 if the coroutine is done. It is accessed atomically,
+	// so it can be checked while the coroutine is running.
+	done int32
+	// caller is the coroutine that resumed this one, if any.
+	caller *Coroutine
+}
+
+// NewCoroutine returns a coroutine that will evaluate the block with
+// the arguments in an environment that is based on env.
+func NewCoroutine(env *Environment, block Block, args ...Value) *Coroutine {
+	child := env.Child()
+	// Share the reader, buffered first so no input is lost.
+	if in := env.BufferedReader(); in != nil {
+		child.In = in
+		child.Bottom().In = in
+	}
+	for i, arg := range args {
+		args[i] = isolate(arg)
+	}
+	co := &Coroutine{Block: block, env: child, args: args}
+	child.Coroutine = co
+	sh := child.Shared
+	sh.Lock()
+	defer sh.Unlock()
+	if sh.coroutines == nil {
+		sh.coroutines = make(map[*Coroutine]struct{})
+	}
+	sh.coroutines[co] = struct{}{}
+	return co
+}
+
+func (co *Coroutine) String() string {
+	return "[coroutine " + co.Block.String() + "]"
+}
+
+func (co *Coroutine) Eval(env *Environment, args ...Value) (Value, Effect) {
+	return co, nil
+}
+
+func (*Coroutine) Type() Type { return Type("Coroutine") }
+
+func (from *Coroutine) Convert(to interface{}) *Error {
+	switch toPtr := to.(type) {
+	case **Coroutine:
+		(*toPtr) = from
+	case *bool:
+		(*toPtr) = !from.Done()
+	case *Bool:
+		(*toPtr) = Bool(!from.Done())
+	case *Value:
+		(*toPtr) = from
+	default:
+		return ErrorFromString("Cannot convert coroutine value")
+	}
+	return nil
+}
+
+// Done returns true if the block of the coroutine has completed.
+func (co *Coroutine) Done() bool {
+	return atomic.LoadInt32(&co.done) != 0
+}
+
+func (co *Coroutine) finish() {
+	atomic.StoreInt32(&co.done, 1)
+	sh := co.env.Shared
+	sh.Lock()
+	defer sh.Unlock()
+	delete(sh.coroutines, co)
+}
+
+// Close stops all coroutines that were created in the environment, in
+// the environments of it's coroutines and tasks, or in the
+// environments these are based on, and that are not done yet. The
+// goroutine of a coroutine only ends when it is done, so a coroutine
+// that is suspended and never resumed until it is done keeps it's
+// goroutine. Hosts should call Close when they are done with an
+// environment and no script is running in it anymore.
+func (env *Environment) Close() {
+	sh := env.Shared
+	if sh == nil {
+		return
+	}
+	sh.Lock()
+	pending := make([]*Coroutine, 0, len(sh.coroutines))
+	for co := range sh.coroutines {
+		pending = append(pending, co)
+	}
+	sh.Unlock()
+	for _, co := range pending {
+		co.Stop()
+	}
+}
+
+func (co *Coroutine) run() {
+	defer func() {
+		if rec := recover(); rec != nil {
+			co.yield <- coResult{eff: panicError(rec), done: true}
+		}
+	}()
+	<-co.resume
+	if err := co.env.Push(); err != nil {
+		co.yield <- coResult{eff: err, done: true}
+		return
+	}
+	val, eff := co.Block.Eval(co.env, co.args...)
+	co.yield <- coResult{val: val, eff: eff, done: true}
+}
+
+// Resume runs the coroutine until it yields or is done. The value
+// becomes the result of the yield that suspended the coroutine.
+// Resume returns the yielded value, or the value of the block and
+// true if the coroutine is done. A failure or exit of the block is
+// returned as the effect. If another task is resuming the coroutine,
+// Resume waits until it is done with that.
+// Resume must not be called from the block of the coroutine itself,
+// use Environment.Resume for that.
+func (co *Coroutine) Resume(val Value) (Value, Effect, bool) {
+	return co.resumeBy(nil, val)
+}
+
+// resumeBy resumes the coroutine on behalf of the caller.
+func (co *Coroutine) resumeBy(caller *Coroutine, val Value) (Value, Effect, bool) {
+	res, eff, done, ok := co.step(caller, val)
+	if !ok {
+		return nil, ErrorFromString("coroutine is done"), true
+	}
+	return res, eff, done
+}
+
+// step resumes the coroutine on behalf of the caller, like resumeBy.
+// It returns false if the coroutine was already done, which is checked
+// while holding the lock, so tasks that share it can tell whether it
+// finished for them or before.
+func (co *Coroutine) step(caller *Coroutine, val Value) (Value, Effect, bool, bool) {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if co.Done() {
+		return nil, nil, true, false
+	}
+	if !co.started {
+		co.started = true
+		co.resume = make(chan Value)
+		co.yield = make(chan coResult)
+		go co.run()
+	}
+	co.caller = caller
+	co.resume <- val
+	res := <-co.yield
+	co.caller = nil
+	if res.done {
+		co.finish()
+		co.resume = nil
+		// Only failures and exits pass on to the caller.
+		if res.eff != nil && res.eff.Flow() != FailFlow && res.eff.Flow() != ExitFlow {
+			res.eff = nil
+		}
+	}
+	return res.val, res.eff, res.done, true
+}
+
+// running returns an error if the coroutine is running in the
+// environment, that is, if it is the coroutine of the environment,
+// or one of the coroutines that resumed it.
+func (env *Environment) running(co *Coroutine) *Error {
+	for caller := env.Coroutine; caller != nil; caller = caller.caller {
+		if caller == co {
+			return ErrorFromString("coroutine is already running")
+		}
+	}
+	return nil
+}
+
+// Resume resumes the coroutine like Coroutine.Resume, but fails in
+// stead of waiting forever if the coroutine is already running in
+// the environment.
+func (env *Environment) Resume(co *Coroutine, val Value) (Value, Effect, bool) {
+	if err := env.running(co); err != nil {
+		return nil, err, false
+	}
+	return co.resumeBy(env.Coroutine, val)
+}
+
+// Next resumes the generator like Resume, but if it is already done,
+// it returns that it is done in stead of failing, like when it
+// finishes now. This way tasks can share a generator.
+func (env *Environment) Next(co *Coroutine) (Value, Effect, bool) {
+	if err := env.running(co); err != nil {
+		return nil, err, false
+	}
+	res, eff, done, _ := co.step(env.Coroutine, nil)
+	return res, eff, done
+}
+
+// Yield suspends the coroutine with the value until it is resumed,
+// and returns the value it is resumed with.
+func (co *Coroutine) Yield(val Value) (Value, Effect) {
+	co.yield <- coResult{val: val}
+	resumed, ok := <-co.resume
+	if !ok {
+		// The coroutine was stopped, unwind it's block.
+		return nil, Exit{}
+	}
+	return resumed, nil
+}
+
+// Stop ends a suspended coroutine. The deferred and finally blocks
+// of the coroutine run, but it's result is discarded. If another task
+// is resuming the coroutine, Stop waits until it is done with that.
+func (co *Coroutine) Stop() {
+	co.mu.Lock()
+	defer co.mu.Unlock()
+	if co.Done() {
+		return
+	}
+	co.finish()
+	if co.started {
+		close(co.resume)
+		<-co.yield
+	}
+	co.resume = nil
+}
+
+func generator(env *Environment, args ...Value) (Value, Effect) {
+	var block Block
+	err := Args(args, &block)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return NewCoroutine(env, block, args[1:]...), nil
+}
+
+func yield(env *Environment, args ...Value) (Value, Effect) {
+	if env.Coroutine == nil {
+		return env.FailString("yield outside of coroutine")
+	}
+	var val Value
+	if len(args) == 1 {
+		val = args[0]
+	} else if len(args) > 1 {
+		val = List(args)
+	}
+	return env.Coroutine.Yield(val)
+}
+
+func resume(env *Environment, args ...Value) (Value, Effect) {
+	var co *Coroutine
+	err := Args(args, &co)
+	if err != nil {
+		return env.Fail(err)
+	}
+	var val Value
+	if len(args) > 1 {
+		val = args[1]
+	}
+	res, eff, _ := env.Resume(co, val)
+	if rerr, ok := eff.(*Error); ok {
+		return env.Fail(rerr)
+	}
+	return res, eff
+}
+
+// next_ returns the next value of a generator, or nil if it is done.
+func next_(env *Environment, args ...Value) (Value, Effect) {
+	var co *Coroutine
+	err := Args(args, &co)
+	if err != nil {
+		return env.Fail(err)
+	}
+	res, eff, done := env.Next(co)
+	if rerr, ok := eff.(*Error); ok {
+		return env.Fail(rerr)
+	} else if done {
+		return nil, eff
+	}
+	return res, eff
+}
+
+func done(env *Environment, args ...Value) (Value, Effect) {
+	var co *Coroutine
+	err := Args(args, &co)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return Bool(co.Done()), nil
+}
+
+func stop(env *Environment, args ...Value) (Value, Effect) {
+	var co *Coroutine
+	err := Args(args, &co)
+	if err != nil {
+		return env.Fail(err)
+	}
+	if err := env.running(co); err != nil {
+		return env.Fail(err)
+	}
+	co.Stop()
+	return nil, nil
+}
+
+// leachCoroutine calls the block for each value the generator yields.
+func leachCoroutine(env *Environment, co *Coroutine, key, name Word, block Block, label Word, args ...Value) (Value, Effect) {
+	defer env.Label(label)()
+	for i := 0; ; i++ {
+		val, eff, done := env.Next(co)
+		if rerr, ok := eff.(*Error); ok {
+			return env.Fail(rerr)
+		} else if eff != nil {
+			return val, eff
+		} else if done {
+			return co, nil
+		}
+		env.Define(key.String(), Int(i), 0)
+		env.Define(name.String(), val, 0)
+		bval, beff := block.Eval(env, args...)
+		bval, beff, stopped := env.LoopEffect(label, bval, beff)
+		if beff != nil {
+			return bval, beff
+		} else if stopped {
+			return co, nil
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package tgtl
+
+import (
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCoroutines(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`let g [generator { yield 1; yield 2; yield 3 }]; print "$1 $2 $3 $4 $5" [next $g] [next $g] [next $g] [next $g] [done $g]`,
+			"1 2 3 !nil true", false},
+		sTestCase{`leach [generator { for i 1 3 { yield [imul $i $i] } }] i v { print "$v " }`,
+			"1 4 9 ", false},
+		sTestCase{`let g [generator { let n 0; while { val $true } { set n [iadd $n 1]; yield $n } }]; leach $g i v { if [eq $v 3] { break }; print "$v " }; print "$1" [next $g]`,
+			"1 2 4", false},
+		sTestCase{`let c [coroutine { let total 0; while { val $true } { set total [iadd $total [yield $total]] } }]; resume $c; resume $c 5; print "$1" [resume $c 10]`,
+			"15", false},
+		sTestCase{`let c [coroutine { yield "a"; return "finished" } x]; print "$1 " [resume $c]; print "$1 $2" [resume $c] [done $c]`,
+			"a finished true", false},
+		sTestCase{`let g [generator { yield $1; yield $2 } "x" "y"]; print "${1}${2}" [next $g] [next $g]`,
+			"xy", false},
+		sTestCase{`to numbers n { generator { for i 1 $n { yield $i } } }; leach [numbers 2] i v { print "$v " }`,
+			"1 2 ", false},
+		sTestCase{`let g [generator { try { yield 1; yield 2 } finally { print "cleanup" } }]; next $g; stop $g; print "$1" [done $g]`,
+			"cleanuptrue", false},
+		sTestCase{`let g [generator { yield 1; fail "broken" }]; next $g; next $g`, "", true},
+		sTestCase{`let c [coroutine { print "x" }]; resume $c; resume $c`, "", true},
+		sTestCase{`yield 1`, "", true},
+		sTestCase{`for i 1 2 { try { resume [coroutine { continue }] } catch { print "f" }; print "x" }`, "fxfx", false},
+		sTestCase{`let g [generator { for i 1 10 { yield $i } }]; let b { let s 0; repeat 5 { set s [iadd $s [next $g]] }; iadd $s 0 }; let t1 [spawn $b]; let t2 [spawn $b]; print "$1" [iadd [wait $t1] [wait $t2]]`,
+			"55", false},
+		sTestCase{`let c [coroutine { try { resume [global c] } catch e { print [emessage $e] } }]; global c $c; resume $c`,
+			"coroutine is already running", false},
+		sTestCase{`let c [coroutine { stop [global c] }]; global c $c; resume $c`, "", true},
+		sTestCase{`let l [list 1]; let g [generator { lset $l 0 2; yield [lget $l 0] }]; print "$1 $2" [next $g] $l`,
+			"2 [list 1]", false},
+		sTestCase{`let g [generator { str $nope }]; next $g`, "", true},
+		sTestCase{`let g [generator { yield 1 }]; leach $g i v {}; leach $g i v { print "x" }; print "ok"`,
+			"ok", false},
+		sTestCase{`let g [generator { for i 1 10 { yield $i } }]; let b { let s 0; leach $g i v { set s [iadd $s $v] }; iadd $s 0 }; let t1 [spawn $b]; let t2 [spawn $b]; print "$1" [iadd [wait $t1] [wait $t2]]`,
+			"55", false},
+	})
+}
+
+func TestCoroutineRecursionFramesMax(t *testing.T) {
+	env := &Environment{}
+	env.Push()
+	env.RegisterBuiltins()
+	defer env.Close()
+	parsed, _ := Parse("to f { next [generator { f }] }\nf\n")
+	if _, eff := parsed.Eval(env); eff == nil || eff.Flow() != FailFlow {
+		t.Errorf("error: expected recursion through generators to fail: %v", eff)
+	}
+}
+
+func TestCoroutinesClose(t *testing.T) {
+	before := runtime.NumGoroutine()
+	env, out := newTestEnvironment()
+	parsed, _ := Parse("let n 0\nwhile { ilt $n 100 } { set n [iadd $n 1]; let g [generator { try { yield 1; yield 2 } finally { print \".\" } }]; next $g }\n")
+	if _, eff := parsed.Eval(env); eff != nil {
+		t.Fatalf("error: unexpected effect: %v", eff)
+	}
+	if pending := len(env.Shared.coroutines); pending != 100 {
+		t.Errorf("error: expected 100 suspended generators, got %d", pending)
+	}
+	env.Close()
+	for i := 0; i < 100 && runtime.NumGoroutine() > before; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if running := runtime.NumGoroutine(); running > before {
+		t.Errorf("error: goroutines leaked: %d > %d", running, before)
+	}
+	if len(out.String()) != 100 {
+		t.Errorf("error: finally blocks not run on close: %q", out.String())
+	}
+}
@@ -0,0 +1,289 @@
+package tgtl
+
+import (
+	"encoding/csv"
+	"io"
+	"strings"
+)
+
+// CSVOptions are the options for reading and writing CSV.
+// In TGTL they are passed as a map or dict with the keys
+// delimiter, comment, header, lazy, trim, crlf, columns and out.
+type CSVOptions struct {
+	// Delimiter is the field delimiter, ',' by default.
+	Delimiter rune
+	// Comment is the comment character for reading, if not 0.
+	Comment rune
+	// Header is true if the first row contains the column names.
+	// Rows are then read as and written from dicts.
+	Header bool
+	// Lazy allows quotes to appear in unquoted fields when reading.
+	Lazy bool
+	// Trim ignores leading white space in fields when reading.
+	Trim bool
+	// CRLF uses \r\n as line terminator when writing.
+	CRLF bool
+	// Columns are the columns to write when writing dicts or maps.
+	// By default the keys of the first row are used.
+	Columns List
+	// Out writes to the environment's writer in stead of to a String.
+	Out bool
+}
+
+func csvRune(val Value) (rune, *Error) {
+	var str string
+	err := Convert(val, &str)
+	if err != nil {
+		return 0, err
+	}
+	runes := []rune(str)
+	if len(runes) != 1 {
+		return 0, ErrorFromString("CSV option must be a single character: " + str)
+	}
+	return runes[0], nil
+}
+
+// CSVOptionsFromMapper converts a map or dict to CSVOptions.
+func CSVOptionsFromMapper(m Mapper) (CSVOptions, *Error) {
+	opts := CSVOptions{Delimiter: ','}
+	var err *Error
+	for _, k := range m.Keys() {
+		v, _ := m.Get(k)
+		switch k.String() {
+		case "delimiter":
+			opts.Delimiter, err = csvRune(v)
+		case "comment":
+			opts.Comment, err = csvRune(v)
+		case "header":
+			err = Convert(v, &opts.Header)
+		case "lazy":
+			err = Convert(v, &opts.Lazy)
+		case "trim":
+			err = Convert(v, &opts.Trim)
+		case "crlf":
+			err = Convert(v, &opts.CRLF)
+		case "out":
+			err = Convert(v, &opts.Out)
+		case "columns":
+			err = Convert(v, &opts.Columns)
+		default:
+			err = ErrorFromString("Unknown CSV option: " + k.String())
+		}
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// csvArgs parses the optional options Mapper at args[index].
+func csvArgs(args []Value, index int) (CSVOptions, int, *Error) {
+	if index < len(args) {
+		if m, ok := Unfrozen(args[index]).(Mapper); ok {
+			opts, err := CSVOptionsFromMapper(m)
+			return opts, index + 1, err
+		}
+	}
+	return CSVOptions{Delimiter: ','}, index, nil
+}
+
+// NewCSVReader returns a csv.Reader configured with the options.
+func (opts CSVOptions) NewCSVReader(in io.Reader) *csv.Reader {
+	reader := csv.NewReader(in)
+	reader.Comma = opts.Delimiter
+	reader.Comment = opts.Comment
+	reader.LazyQuotes = opts.Lazy
+	reader.TrimLeadingSpace = opts.Trim
+	reader.FieldsPerRecord = -1
+	reader.ReuseRecord = true
+	return reader
+}
+
+// NewCSVWriter returns a csv.Writer configured with the options.
+func (opts CSVOptions) NewCSVWriter(out io.Writer) *csv.Writer {
+	writer := csv.NewWriter(out)
+	writer.Comma = opts.Delimiter
+	writer.UseCRLF = opts.CRLF
+	return writer
+}
+
+func csvError(err error) *Error {
+	if perr, ok := err.(*csv.ParseError); ok {
+		return NewError("CSV "+perr.Error(), perr.Line)
+	}
+	return ErrorFromError(err)
+}
+
+// readCSV reads the rows one by one, and calls each for every row.
+func readCSV(reader *csv.Reader, opts CSVOptions, each func(row Value) (Value, Effect)) (Value, Effect) {
+	var header []string
+	var res Value
+	var eff Effect
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return res, eff
+		}
+		if err != nil {
+			return nil, csvError(err)
+		}
+		if opts.Header && header == nil {
+			header = make([]string, len(record))
+			copy(header, record)
+			continue
+		}
+		var row Value
+		if opts.Header {
+			dict, _ := NewDict()
+			for i, field := range record {
+				if i < len(header) {
+					dict.Set(String(header[i]), String(field))
+				} else {
+					dict.Set(Int(i), String(field))
+				}
+			}
+			row = dict
+		} else {
+			row = StringList(record...)
+		}
+		res, eff = each(row)
+		if eff != nil {
+			return res, eff
+		}
+	}
+}
+
+// csvRecord converts a row to a CSV record.
+func csvRecord(row Value, opts *CSVOptions) ([]string, *Error) {
+	switch rv := Unfrozen(row).(type) {
+	case List:
+		return rv.ToStrings(), nil
+	case Mapper:
+		if opts.Columns == nil {
+			opts.Columns = OrderedKeys(rv)
+		}
+		res := []string{}
+		for _, k := range opts.Columns {
+			v, _ := rv.Get(k)
+			if v == nil {
+				res = append(res, "")
+			} else {
+				res = append(res, v.String())
+			}
+		}
+		return res, nil
+	default:
+		return nil, ErrorFromString("CSV row must be a list, map or dict")
+	}
+}
+
+func csvRead(env *Environment, args ...Value) (Value, Effect) {
+	var source Value
+	err := Args(args, &source)
+	if err != nil {
+		return env.Fail(err)
+	}
+	if source == nil {
+		return env.FailKind(ArgumentError, "csv_read: $1 is nil")
+	}
+	opts, index, err := csvArgs(args, 1)
+	if err != nil {
+		return env.Fail(err)
+	}
+	var in io.Reader
+	if word, ok := source.(Word); ok && word == "in" {
+		buffered := env.BufferedReader()
+		if buffered == nil {
+			return env.FailString("csv_read: no reader set in environment")
+		}
+		in = buffered
+	} else {
+		in = strings.NewReader(source.String())
+	}
+	reader := opts.NewCSVReader(in)
+	if index >= len(args) {
+		rows := List{}
+		_, eff := readCSV(reader, opts, func(row Value) (Value, Effect) {
+			rows = append(rows, row)
+			return rows, nil
+		})
+		if eff != nil {
+			return env.Fail(eff.(*Error))
+		}
+		return rows, nil
+	}
+	var name Word
+	var block Block
+	err = Args(args[index:], &name, &block)
+	if err != nil {
+		return env.Fail(err)
+	}
+	label, err := loopLabel(args, index+2)
+	if err != nil {
+		return env.Fail(err)
+	}
+	defer env.Label(label)()
+	count := 0
+	stopped := false
+	_, eff := readCSV(reader, opts, func(row Value) (Value, Effect) {
+		count++
+		env.Define(name.String(), row, 0)
+		val, eff := block.Eval(env, args...)
+		val, eff, done := env.LoopEffect(label, val, eff)
+		if done && eff == nil {
+			// Stop reading when the block breaks out of the loop.
+			stopped = true
+			return val, Break{Value: val}
+		}
+		return val, eff
+	})
+	if rerr, ok := eff.(*Error); ok {
+		return env.Fail(rerr)
+	} else if eff != nil && !stopped {
+		return nil, eff
+	}
+	return Int(count), nil
+}
+
+func csvWrite(env *Environment, args ...Value) (Value, Effect) {
+	var rows List
+	err := Args(args, &rows)
+	if err != nil {
+		return env.Fail(err)
+	}
+	opts, _, err := csvArgs(args, 1)
+	if err != nil {
+		return env.Fail(err)
+	}
+	buf := &strings.Builder{}
+	var out io.Writer = buf
+	if opts.Out {
+		out = env.Writer()
+		if out == nil {
+			return env.FailString("csv_write: no writer set in environment")
+		}
+	}
+	writer := opts.NewCSVWriter(out)
+	for i, row := range rows {
+		record, err := csvRecord(row, &opts)
+		if err != nil {
+			return env.Fail(err)
+		}
+		if i == 0 && opts.Header && opts.Columns != nil {
+			if werr := writer.Write(opts.Columns.ToStrings()); werr != nil {
+				return env.Fail(csvError(werr))
+			}
+		}
+		if werr := writer.Write(record); werr != nil {
+			return env.Fail(csvError(werr))
+		}
+	}
+	writer.Flush()
+	if werr := writer.Error(); werr != nil {
+		return env.Fail(csvError(werr))
+	}
+	if opts.Out {
+		return Int(len(rows)), nil
+	}
+	return String(buf.String()), nil
+}
@@ -0,0 +1,187 @@
+package tgtl
+
+// Mapper is an interface to Values that associate keys with values,
+// such as Map and *Dict. The map builtins work on any Mapper.
+type Mapper interface {
+	Value
+	// Get returns the value for the key and whether it was present.
+	Get(key Value) (Value, bool)
+	// Set sets the value for the key.
+	Set(key Value, val Value) *Error
+	// Delete removes the key, if present.
+	Delete(key Value)
+	// Keys returns the keys in iteration order.
+	Keys() List
+	// SortedKeys returns the keys sorted by their string value.
+	SortedKeys() List
+	// Len returns the amount of entries.
+	Len() int
+}
+
+// Dict is an insertion ordered dictionary. Unlike a Map,
+// it remembers the order in which keys were added, and it can use any
+// hashable value as key, that is, an Int, String, Word or Bool.
+// Word keys are stored as String keys, so "a" and a are the same key,
+// but String("1") and Int(1) are different keys.
+type Dict struct {
+	keys   List
+	values map[Value]Value
+}
+
+// NewDict returns a new Dict filled with the given key value pairs.
+func NewDict(pairs ...Value) (*Dict, *Error) {
+	dict := &Dict{List{}, make(map[Value]Value)}
+	for i := 1; i < len(pairs); i += 2 {
+		err := dict.Set(pairs[i-1], pairs[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return dict, nil
+}
+
+// IsHashable returns true if the value can be used as a Dict key.
+func IsHashable(key Value) bool {
+	switch key.(type) {
+	case Int, String, Word, Bool:
+		return true
+	default:
+		return false
+	}
+}
+
+// dictKey normalizes Word keys to String keys.
+func dictKey(key Value) Value {
+	if word, ok := key.(Word); ok {
+		return String(word)
+	}
+	return key
+}
+
+func (d *Dict) Get(key Value) (Value, bool) {
+	if !IsHashable(key) {
+		return nil, false
+	}
+	val, ok := d.values[dictKey(key)]
+	return val, ok
+}
+
+func (d *Dict) Set(key Value, val Value) *Error {
+	if !IsHashable(key) {
+		return ErrorFromString("Dict key is not hashable: " + TypeOf(key).String())
+	}
+	key = dictKey(key)
+	if _, ok := d.values[key]; !ok {
+		d.keys = append(d.keys, key)
+	}
+	d.values[key] = val
+	return nil
+}
+
+func (d *Dict) Delete(key Value) {
+	if _, ok := d.Get(key); !ok {
+		return
+	}
+	key = dictKey(key)
+	delete(d.values, key)
+	for i, k := range d.keys {
+		if k == key {
+			d.keys = append(d.keys[0:i:i], d.keys[i+1:]...)
+			break
+		}
+	}
+}
+
+// Keys returns the keys of the dictionary in insertion order.
+func (d *Dict) Keys() List {
+	res := make(List, len(d.keys))
+	copy(res, d.keys)
+	return res
+}
+
+func (d *Dict) SortedKeys() List {
+	return d.Keys().SortStrings()
+}
+
+func (d *Dict) Len() int {
+	return len(d.keys)
+}
+
+func (d *Dict) String() string {
+	aid := "[dict"
+	for _, k := range d.keys {
+		aid += " "
+		aid += k.String()
+		aid += " "
+		v := d.values[k]
+		if v == nil {
+			aid += "nil"
+		} else {
+			aid += v.String()
+		}
+	}
+	aid += "]"
+	return aid
+}
+
+func (d *Dict) Eval(env *Environment, args ...Value) (Value, Effect) {
+	return d, nil
+}
+
+func (*Dict) Type() Type { return Type("Dict") }
+
+func (from *Dict) Convert(to interface{}) *Error {
+	switch toPtr := to.(type) {
+	case *bool:
+		(*toPtr) = (from.Len() > 0)
+	case *Bool:
+		(*toPtr) = (from.Len() > 0)
+	case **Dict:
+		(*toPtr) = from
+	case *Mapper:
+		(*toPtr) = from
+	case *Value:
+		(*toPtr) = from
+	default:
+		return ErrorFromString("Cannot convert dict value")
+	}
+	return nil
+}
+
+// Implement the Mapper interface for Map.
+// Map keys are always converted to strings.
+
+func (m Map) Get(key Value) (Value, bool) {
+	if key == nil {
+		return nil, false
+	}
+	val, ok := m[key.String()]
+	return val, ok
+}
+
+func (m Map) Set(key Value, val Value) *Error {
+	if key == nil {
+		return ErrorFromString("Map key is nil")
+	}
+	m[key.String()] = val
+	return nil
+}
+
+func (m Map) Delete(key Value) {
+	if key != nil {
+		delete(m, key.String())
+	}
+}
+
+func (m Map) Len() int {
+	return len(m)
+}
+
+// OrderedKeys returns the keys of a Mapper in a deterministic order,
+// that is, sorted for a Map, and in iteration order otherwise.
+func OrderedKeys(m Mapper) List {
+	if _, ok := m.(Map); ok {
+		return m.SortedKeys()
+	}
+	return m.Keys()
+}
@@ -7,7 +7,7 @@ type Flow int
 // No effect
 const NormalFlow Flow = 0
 
-// Breaks out of the current block
+// Breaks out of the current loop
 const BreakFlow Flow = 1
 
 // Breaks out of the current command
@@ -16,6 +16,18 @@ const ReturnFlow Flow = 2
 // Error, breaks until rescue block is ofound
 const FailFlow Flow = 4
 
+// Exits the script, can not be rescued
+const ExitFlow Flow = 8
+
+// Skips to the next iteration of the current loop
+const ContinueFlow Flow = 16
+
+// Suspends a resumable script at a checkpoint
+const SuspendFlow Flow = 32
+
+// Passes a tail call from the block of a branch to it's procedure
+const tailFlow Flow = 64
+
 // Every tgtl command evaluates to a value, which is the result
 // of the command itself, but also an Effect that describes
 // it's special effect on the flow of evaluation itself.
@@ -1,9 +1,18 @@
 package tgtl
 
+import (
+	"context"
+	"math/rand"
+)
+
 // Maximum amount of frames,
 // to prevent unlimited recursion.
 const FRAMES_MAX = 80
 
+// Default maximum amount of iterations of a bounded loop,
+// and of elements of a range, to guarantee termination.
+const LOOPS_MAX = 100000
+
 type Writer interface {
 	Write(p []byte) (n int, err error)
 }
@@ -18,6 +27,50 @@ type Frame struct {
 	Out     Writer
 	In      Reader
 	Rescuer Value
+	Err     Writer
+	// Procedure is true for the frames of defined procedures.
+	Procedure bool
+	// Deferred are the blocks to run when the procedure exits.
+	Deferred []Block
+	// Base are variables that are shared with other environments
+	// and must not be modified. Variables takes precedence over Base,
+	// and lists, maps, dicts and overloads are copied from Base to Variables
+	// when they are looked up, so they can be modified safely.
+	Base Map
+}
+
+// Get returns the variable of the frame with the name, if it exists.
+func (frame *Frame) Get(name string) (Value, bool) {
+	val, ok := frame.Variables[name]
+	if ok || frame.Base == nil {
+		return val, ok
+	}
+	val, ok = frame.Base[name]
+	if !ok {
+		return nil, false
+	}
+	switch val.(type) {
+	case List, Map, *Dict, Overload:
+		val = isolate(val)
+		frame.Variables[name] = val
+	}
+	return val, true
+}
+
+// All returns all the variables of the frame, including the base ones.
+// The returned map must not be modified.
+func (frame *Frame) All() Map {
+	if frame.Base == nil {
+		return frame.Variables
+	}
+	all := make(Map, len(frame.Base)+len(frame.Variables))
+	for name, val := range frame.Base {
+		all[name] = val
+	}
+	for name, val := range frame.Variables {
+		all[name] = val
+	}
+	return all
 }
 
 type Environment struct {
@@ -25,13 +78,71 @@ type Environment struct {
 	Out      Writer
 	In       Reader
 	Rescuing bool
+	Err      Writer
+	// FS is the file system used by the file builtins.
+	// If nil, scripts cannot access any files.
+	FS FileSystem
+	// Context, if set, can be used to cancel the evaluation
+	// or limit it's duration. It is also used to stop
+	// commands started by the exec builtins.
+	Context context.Context
+	// ExecPolicy is the policy for the exec builtins.
+	// If nil, scripts cannot execute any programs.
+	ExecPolicy *ExecPolicy
+	// FramesMax is the maximum amount of frames, which limits the
+	// depth of recursion. If zero, FRAMES_MAX is used.
+	FramesMax int
+	// LoopsMax is the maximum amount of iterations of the bounded
+	// loops repeat and for, and of elements produced by range.
+	// If zero, LOOPS_MAX is used.
+	LoopsMax int
+	// Shared are the variables that are shared with spawned tasks.
+	// It is created when the first task is spawned.
+	Shared *Shared
+	// Coroutine is the coroutine that is running in this environment,
+	// if any.
+	Coroutine *Coroutine
+	// Labels are the labels of the loops that are running in the
+	// current procedure, empty for loops without a label.
+	Labels []Word
+	// Clock is used by the time builtins.
+	// If nil, the system clock is used.
+	Clock Clock
+	// Random is the random number generator used by the rand builtins.
+	// Set it to make the results reproducible. If nil, a generator
+	// seeded with the current time is created when needed.
+	Random *rand.Rand
+	// Resumable is the resumable script that is running in this
+	// environment, if any.
+	Resumable *Resumable
+	// tailDepth is the depth at which a branch evaluates it's block
+	// in tail position, or zero if there is none.
+	tailDepth int
+	// unbounded is true if RegisterTuringCompleteBuiltins was called,
+	// so evaluation does not need to terminate.
+	unbounded bool
+	// outer is the amount of frames of the environments this one is a
+	// child of, which count towards the maximum amount of frames.
+	outer int
+}
+
+// Canceled returns an error if the context of the environment
+// has been canceled or has timed out, and nil otherwise.
+func (env *Environment) Canceled() *Error {
+	if env.Context == nil {
+		return nil
+	}
+	if err := env.Context.Err(); err != nil {
+		return ErrorFromError(err).WithKind(QuotaExceeded)
+	}
+	return nil
 }
 
 // Looks up the value of a variable and the frame it is in
 func (env Environment) LookupFrame(name string) (Value, *Frame) {
 	for i := len(env.Frames) - 1; i >= 0; i-- {
 		frame := env.Frames[i]
-		val, ok := frame.Variables[name]
+		val, ok := frame.Get(name)
 		if ok {
 			return val, frame
 		}
@@ -40,18 +151,104 @@ func (env Environment) LookupFrame(name string) (Value, *Frame) {
 }
 
 func (env Environment) Lookup(name string) Value {
-	val, _ := env.LookupFrame(name)
+	val, frame := env.LookupFrame(name)
+	if frame == nil && env.Shared != nil {
+		val, _ = env.Shared.Get(name)
+	}
 	return val
 }
 
+// Push pushes a new frame on the frame stack. If there are too many
+// frames already, it returns an error and does not push the frame, so
+// callers must only Pop if Push did not return an error.
 func (env *Environment) Push() *Error {
-	env.Frames = append(env.Frames, &Frame{make(Map), nil, env.Out, env.In, nil})
-	if len(env.Frames) >= FRAMES_MAX && !env.Rescuing {
-		return ErrorFromString("PROGRAM HAS DISAPPEARED INTO THE BLACK LAGOON - too much recursion or function calls")
+	frame := &Frame{Variables: make(Map), Out: env.Out, In: env.In, Err: env.Err}
+	// New frames inherit the streams of the top frame,
+	// so redirections apply to nested commands as well.
+	if top := env.Top(); top != nil {
+		frame.Out, frame.In, frame.Err = top.Out, top.In, top.Err
+	}
+	// The frame is not pushed if there are too many,
+	// so the caller should only Pop if there was no error.
+	if env.outer+len(env.Frames)+1 >= env.MaxFrames() && !env.Rescuing {
+		return ErrorWithKind(QuotaExceeded, "PROGRAM HAS DISAPPEARED INTO THE BLACK LAGOON - too much recursion or function calls")
 	}
+	env.Frames = append(env.Frames, frame)
 	return nil
 }
 
+// CheckLoops returns an error if the amount of iterations
+// is more than the bounded loops of the environment allow.
+func (env *Environment) CheckLoops(amount Int) *Error {
+	max := env.LoopsMax
+	if max == 0 {
+		max = LOOPS_MAX
+	}
+	if amount > Int(max) {
+		return ErrorWithKind(QuotaExceeded, "too many iterations: "+
+			amount.String()+", at most "+Itoa(max)+" allowed")
+	}
+	return nil
+}
+
+// Restricted returns true if the environment only allows evaluation
+// that terminates, that is, if RegisterTuringCompleteBuiltins was not
+// called for it. Scripts cannot change this by defining while.
+func (env *Environment) Restricted() bool {
+	return !env.unbounded
+}
+
+// MaxFrames returns the maximum amount of frames of the environment.
+func (env *Environment) MaxFrames() int {
+	if env.FramesMax == 0 {
+		return FRAMES_MAX
+	}
+	return env.FramesMax
+}
+
+// Defer queues the block to be run when the nearest
+// procedure that is being evaluated exits.
+func (env *Environment) Defer(block Block) *Error {
+	for i := len(env.Frames) - 1; i >= 0; i-- {
+		frame := env.Frames[i]
+		if frame.Procedure {
+			frame.Deferred = append(frame.Deferred, block)
+			return nil
+		}
+	}
+	return ErrorFromString("defer outside of procedure")
+}
+
+// RunDeferred runs the deferred blocks of the top frame in reverse
+// order. The result and effect of the procedure are returned, unless
+// a deferred block exits, or fails while the procedure did not fail
+// or exit. Returns, breaks and continues only end the deferred block
+// itself, they do not change the result of the procedure.
+func (env *Environment) RunDeferred(val Value, eff Effect) (Value, Effect) {
+	frame := env.Top()
+	if frame == nil {
+		return val, eff
+	}
+	for len(frame.Deferred) > 0 {
+		last := len(frame.Deferred) - 1
+		block := frame.Deferred[last]
+		frame.Deferred = frame.Deferred[0:last]
+		dval, deff := block.Eval(env)
+		if deff == nil || (eff != nil && eff.Flow() == ExitFlow) {
+			continue
+		}
+		switch deff.Flow() {
+		case ExitFlow:
+			val, eff = dval, deff
+		case FailFlow:
+			if eff == nil || eff.Flow() != FailFlow {
+				val, eff = nil, deff
+			}
+		}
+	}
+	return val, eff
+}
+
 func (env *Environment) Pop() {
 	l := len(env.Frames)
 	if l > 0 {
@@ -118,6 +315,11 @@ func (env *Environment) Define(name string, val Value, level int) (Value, Effect
 func (env *Environment) Set(name string, val Value) (Value, Effect) {
 	_, frame := env.LookupFrame(name)
 	if frame == nil {
+		if env.Shared != nil {
+			if _, ok := env.Shared.Get(name); ok {
+				return env.Shared.Set(name, val), nil
+			}
+		}
 		return nil, ErrorFromString("no such variable")
 	}
 	frame.Variables[name] = val
@@ -147,7 +349,7 @@ func (env *Environment) Prevent(block Block) (Value, Effect) {
 
 //
 func (env *Environment) Rescue(res Value, eff Effect) (Value, Effect) {
-	if eff == nil || eff.Flow() < FailFlow {
+	if eff == nil || eff.Flow() != FailFlow {
 		return res, eff
 	}
 	// if there is no rescue installed,
@@ -195,10 +397,92 @@ func (env *Environment) Fail(err *Error) (Value, Effect) {
 }
 
 func (env *Environment) Break(val Value) (Value, Effect) {
-	effect := env.SetEffect(Break{val})
+	effect := env.SetEffect(Break{Value: val})
+	return val, effect
+}
+
+// BreakLabel breaks out of the loop with the given label.
+func (env *Environment) BreakLabel(label Word, val Value) (Value, Effect) {
+	effect := env.SetEffect(Break{Value: val, Label: label})
 	return val, effect
 }
 
+// Continue skips to the next iteration of the loop with the given
+// label, or of the innermost loop if the label is empty.
+func (env *Environment) Continue(label Word) (Value, Effect) {
+	effect := env.SetEffect(Continue{Label: label})
+	return nil, effect
+}
+
+// Label marks the start of a loop with the label, which may be empty,
+// so break and continue can refer to it. The returned function must
+// be called when the loop ends.
+func (env *Environment) Label(label Word) func() {
+	env.Labels = append(env.Labels, label)
+	return func() {
+		env.Labels = env.Labels[0 : len(env.Labels)-1]
+	}
+}
+
+// IsLabel returns true if the label is the label of an active loop.
+func (env *Environment) IsLabel(label Word) bool {
+	if label == "" {
+		return false
+	}
+	for _, active := range env.Labels {
+		if active == label {
+			return true
+		}
+	}
+	return false
+}
+
+// InLoop returns true if a loop is active. Loops are scoped to the
+// procedure they run in, so loops that called the procedure
+// are not active in it.
+func (env *Environment) InLoop() bool {
+	return len(env.Labels) > 0
+}
+
+// ScopeLabels starts a new scope for the labels of loops, in which
+// no loop is active. The returned function must be called to restore
+// the previous scope.
+func (env *Environment) ScopeLabels() func() {
+	labels := env.Labels
+	env.Labels = nil
+	return func() {
+		env.Labels = labels
+	}
+}
+
+// LoopEffect handles the result of one iteration of the body of the
+// loop with the given label. It returns the value and effect of the
+// iteration, and true if the loop must stop. Breaks of the loop end
+// it normally with the value of the break, and continues of the loop
+// go on with the next iteration. Other effects stop the loop and
+// are passed on.
+func (env *Environment) LoopEffect(label Word, val Value, eff Effect) (Value, Effect, bool) {
+	if eff == nil || eff.Flow() == NormalFlow {
+		return val, nil, false
+	}
+	switch flow := eff.(type) {
+	case Break:
+		if flow.Label == "" || flow.Label == label {
+			return flow.Value, nil, true
+		}
+	case Continue:
+		if flow.Label == "" || flow.Label == label {
+			return val, nil, false
+		}
+	}
+	return val, eff, true
+}
+
+func (env *Environment) Exit(code Int) (Value, Effect) {
+	effect := env.SetEffect(Exit{code})
+	return code, effect
+}
+
 func (env *Environment) FailString(msg string, args ...Value) (Value, Effect) {
 	return env.Fail(env.ErrorFromString(msg, args...))
 }
@@ -276,18 +560,51 @@ func (env Environment) Printi(msg string, args ...Value) (int, error) {
 	return env.Write(msg)
 }
 
-func (env Environment) Write(msg string) (int, error) {
-	buf := []byte(msg)
-	writer := env.Out
+// Writer returns the writer of the top frame, or of the
+// environment if there are no frames.
+func (env Environment) Writer() Writer {
+	if len(env.Frames) > 0 {
+		return env.Frames[len(env.Frames)-1].Out
+	}
+	return env.Out
+}
+
+// ErrWriter returns the error writer of the top frame, or of the
+// environment if there are no frames.
+func (env Environment) ErrWriter() Writer {
 	if len(env.Frames) > 0 {
-		writer = env.Frames[len(env.Frames)-1].Out
+		return env.Frames[len(env.Frames)-1].Err
 	}
+	return env.Err
+}
+
+// Reader returns the reader of the top frame, or of the
+// environment if there are no frames.
+func (env Environment) Reader() Reader {
+	if len(env.Frames) > 0 {
+		return env.Frames[len(env.Frames)-1].In
+	}
+	return env.In
+}
+
+func (env Environment) Write(msg string) (int, error) {
+	buf := []byte(msg)
+	writer := env.Writer()
 	if writer == nil {
 		return -1, env.ErrorFromString("no writer set in environment.")
 	}
 	return writer.Write(buf)
 }
 
+// WriteErr writes the message to the error writer.
+func (env Environment) WriteErr(msg string) (int, error) {
+	writer := env.ErrWriter()
+	if writer == nil {
+		return -1, env.ErrorFromString("no error writer set in environment.")
+	}
+	return writer.Write([]byte(msg))
+}
+
 func (env Environment) ErrorFromString(msg string, args ...Value) *Error {
 	msg = env.Interpolate(msg, args...)
 	return ErrorFromString(msg)
@@ -297,7 +614,7 @@ func (env Environment) ErrorFromString(msg string, args ...Value) *Error {
 func (env Environment) Complete(prefix String) List {
 	res := List{}
 	for _, frame := range env.Frames {
-		for name, _ := range frame.Variables {
+		for name, _ := range frame.All() {
 			if len(name) >= len(prefix) {
 				if String(name[0:len(prefix)]) == prefix {
 					res = append(res, String(name))
@@ -0,0 +1,272 @@
+package tgtl
+
+// Equaler is an interface that Values can optionally implement
+// to compare themselves for equality with another Value.
+type Equaler interface {
+	Equal(other Value) bool
+}
+
+// Hasher is an interface that Values can optionally implement
+// to return a hash code of themselves. Values that are Equal
+// must have the same hash.
+type Hasher interface {
+	Hash() uint64
+}
+
+// Orderer is an interface that Values can optionally implement
+// to order themselves relative to another Value of the same type.
+// Compare returns a negative number if the value sorts before other,
+// 0 if they are equal, and a positive number otherwise.
+type Orderer interface {
+	Compare(other Value) int
+}
+
+// Equal returns true if the two values are equal.
+// Values of different types are never equal. Lists, maps, dicts and
+// objects are compared deeply, and frozen values compare equal to the
+// values they wrap. Values that do not implement Equaler are equal if
+// their string forms are.
+func Equal(a, b Value) bool {
+	a, b = Unfrozen(a), Unfrozen(b)
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	if eq, ok := a.(Equaler); ok {
+		return eq.Equal(b)
+	}
+	return TypeOf(a) == TypeOf(b) && a.String() == b.String()
+}
+
+// Compare orders two values. nil sorts before all other values,
+// and values of different types are ordered by their type name.
+// Values that do not implement Orderer are ordered by their string forms.
+func Compare(a, b Value) int {
+	a, b = Unfrozen(a), Unfrozen(b)
+	if a == nil || b == nil {
+		if a != nil {
+			return 1
+		} else if b != nil {
+			return -1
+		}
+		return 0
+	}
+	ta, tb := TypeOf(a), TypeOf(b)
+	if ta != tb {
+		return compareStrings(ta.String(), tb.String())
+	}
+	if cmp, ok := a.(Orderer); ok {
+		return cmp.Compare(b)
+	}
+	return compareStrings(a.String(), b.String())
+}
+
+// Hash returns a hash code for the value. Values that do not implement
+// Hasher are hashed based on their type and string forms.
+func Hash(val Value) uint64 {
+	val = Unfrozen(val)
+	if val == nil {
+		return fnvOffset
+	}
+	if hasher, ok := val.(Hasher); ok {
+		return hasher.Hash()
+	}
+	return hashString(hashString(fnvOffset, TypeOf(val).String()), val.String())
+}
+
+func compareStrings(s1, s2 string) int {
+	if s1 > s2 {
+		return 1
+	} else if s1 < s2 {
+		return -1
+	}
+	return 0
+}
+
+const fnvOffset uint64 = 14695981039346656037
+const fnvPrime uint64 = 1099511628211
+
+// hashString continues a FNV-1a hash with the bytes of s.
+func hashString(hash uint64, s string) uint64 {
+	for i := 0; i < len(s); i++ {
+		hash ^= uint64(s[i])
+		hash *= fnvPrime
+	}
+	return hash
+}
+
+func hashTyped(t Type, s string) uint64 {
+	return hashString(hashString(fnvOffset, t.String()), s)
+}
+
+func (iv Int) Equal(other Value) bool {
+	ov, ok := other.(Int)
+	return ok && iv == ov
+}
+
+func (iv Int) Compare(other Value) int {
+	ov, _ := other.(Int)
+	if iv > ov {
+		return 1
+	} else if iv < ov {
+		return -1
+	}
+	return 0
+}
+
+func (iv Int) Hash() uint64 {
+	return hashTyped(iv.Type(), iv.String())
+}
+
+func (bv Bool) Equal(other Value) bool {
+	ov, ok := other.(Bool)
+	return ok && bv == ov
+}
+
+func (bv Bool) Compare(other Value) int {
+	ov, _ := other.(Bool)
+	if bv == ov {
+		return 0
+	} else if bv {
+		return 1
+	}
+	return -1
+}
+
+func (bv Bool) Hash() uint64 {
+	return hashTyped(bv.Type(), bv.String())
+}
+
+func (sv String) Equal(other Value) bool {
+	ov, ok := other.(String)
+	return ok && sv == ov
+}
+
+func (sv String) Compare(other Value) int {
+	return compareStrings(sv.String(), other.String())
+}
+
+func (sv String) Hash() uint64 {
+	return hashTyped(sv.Type(), sv.String())
+}
+
+func (wv Word) Equal(other Value) bool {
+	ov, ok := other.(Word)
+	return ok && wv == ov
+}
+
+func (wv Word) Compare(other Value) int {
+	return compareStrings(wv.String(), other.String())
+}
+
+func (wv Word) Hash() uint64 {
+	return hashTyped(wv.Type(), wv.String())
+}
+
+func (tv Type) Equal(other Value) bool {
+	ov, ok := other.(Type)
+	return ok && tv == ov
+}
+
+func (tv Type) Hash() uint64 {
+	return hashTyped(tv.Type(), tv.String())
+}
+
+func (ev *Error) Equal(other Value) bool {
+	ov, ok := other.(*Error)
+	if !ok || ev == nil || ov == nil {
+		return ok && ev == ov
+	}
+	return ev.Message == ov.Message && ErrorKind(ev) == ErrorKind(ov)
+}
+
+func (lv List) Equal(other Value) bool {
+	ov, ok := other.(List)
+	if !ok || len(lv) != len(ov) {
+		return false
+	}
+	for i := range lv {
+		if !Equal(lv[i], ov[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Compare orders lists element by element, and shorter lists first.
+func (lv List) Compare(other Value) int {
+	ov, _ := other.(List)
+	for i := 0; i < len(lv) && i < len(ov); i++ {
+		if cmp := Compare(lv[i], ov[i]); cmp != 0 {
+			return cmp
+		}
+	}
+	return len(lv) - len(ov)
+}
+
+func (lv List) Hash() uint64 {
+	hash := hashString(fnvOffset, lv.Type().String())
+	for _, v := range lv {
+		hash = (hash ^ Hash(v)) * fnvPrime
+	}
+	return hash
+}
+
+// equalMappers compares two Mappers entry by entry, regardless of order.
+func equalMappers(m1, m2 Mapper) bool {
+	if m1.Len() != m2.Len() {
+		return false
+	}
+	for _, k := range m1.Keys() {
+		v1, _ := m1.Get(k)
+		v2, ok := m2.Get(k)
+		if !ok || !Equal(v1, v2) {
+			return false
+		}
+	}
+	return true
+}
+
+// hashMapper hashes the entries of a Mapper regardless of their order.
+func hashMapper(t Type, m Mapper) uint64 {
+	var sum uint64
+	for _, k := range m.Keys() {
+		v, _ := m.Get(k)
+		sum += (Hash(k) ^ Hash(v)) * fnvPrime
+	}
+	return hashString(fnvOffset, t.String()) ^ sum
+}
+
+func (mv Map) Equal(other Value) bool {
+	ov, ok := other.(Map)
+	return ok && equalMappers(mv, ov)
+}
+
+// Compare orders maps by their sorted [list key value] pairs.
+func (mv Map) Compare(other Value) int {
+	ov, _ := other.(Map)
+	return mv.Pairs().Compare(ov.Pairs())
+}
+
+func (mv Map) Hash() uint64 {
+	return hashMapper(mv.Type(), mv)
+}
+
+// Equal compares dictionaries by their entries, regardless of their order.
+func (d *Dict) Equal(other Value) bool {
+	ov, ok := other.(*Dict)
+	return ok && equalMappers(d, ov)
+}
+
+func (d *Dict) Hash() uint64 {
+	return hashMapper(d.Type(), d)
+}
+
+func (sv Object) Equal(other Value) bool {
+	ov, ok := other.(Object)
+	return ok && sv.Kind == ov.Kind &&
+		Equal(sv.Fields, ov.Fields) && Equal(sv.Embedded, ov.Embedded)
+}
+
+func (sv Object) Hash() uint64 {
+	return hashString(sv.Fields.Hash(), sv.Kind.String()) ^ sv.Embedded.Hash()
+}
@@ -0,0 +1,31 @@
+package tgtl
+
+import "testing"
+
+func TestEqual(t *testing.T) {
+	if !Equal(nil, nil) {
+		t.Errorf("nil should equal nil")
+	}
+	if Equal(Int(1), nil) || Equal(nil, Int(1)) {
+		t.Errorf("nil should not equal a value")
+	}
+	if Equal(Int(1), String("1")) {
+		t.Errorf("different types should not be equal")
+	}
+	l1 := List{Int(1), Map{"a": List{Word("b")}}}
+	l2 := List{Int(1), Map{"a": List{Word("b")}}}
+	if !Equal(l1, l2) {
+		t.Errorf("lists should be deeply equal: %v<->%v", l1, l2)
+	}
+	if Hash(l1) != Hash(l2) {
+		t.Errorf("equal lists should have equal hashes")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	sorted := List{Int(3), nil, String("b"), Int(-1), String("a")}.Sort(Compare)
+	expect := List{nil, Int(-1), Int(3), String("a"), String("b")}
+	if !Equal(sorted, expect) {
+		t.Errorf("Not equal: %v<->%v", sorted, expect)
+	}
+}
@@ -0,0 +1,152 @@
+package tgtl
+
+import "errors"
+
+// The kinds of errors raised by the builtins and the interpreter.
+// Embedders can use the kind to decide how to report a failure,
+// for example to map it onto a HTTP status code.
+const (
+	// GenericError is the kind of errors that do not have a kind.
+	GenericError Type = "Error"
+	// ArgumentError is raised if a command has too few or wrong arguments.
+	ArgumentError Type = "ArgumentError"
+	// TypeError is raised if a value cannot be converted to the type needed.
+	TypeError Type = "TypeError"
+	// IndexError is raised if an index or key is out of range.
+	IndexError Type = "IndexError"
+	// ArithmeticError is raised for invalid arithmetic like division by zero.
+	ArithmeticError Type = "ArithmeticError"
+	// QuotaExceeded is raised if a script uses too many resources,
+	// such as too many frames, or is canceled.
+	QuotaExceeded Type = "QuotaExceeded"
+	// UserError is the kind of errors raised by fail without a kind.
+	UserError Type = "UserError"
+)
+
+// ErrorKinds are the predefined error kinds.
+var ErrorKinds = []Type{
+	GenericError, ArgumentError, TypeError, IndexError,
+	ArithmeticError, QuotaExceeded, UserError,
+}
+
+// ErrorWithKind returns a new error with the given kind and message.
+func ErrorWithKind(kind Type, message string) *Error {
+	err := ErrorFromString(message)
+	err.Kind = kind
+	return err
+}
+
+// WithKind sets the kind of the error if it does not have one yet,
+// and returns the error.
+func (ev *Error) WithKind(kind Type) *Error {
+	if ev != nil && ev.Kind == "" {
+		ev.Kind = kind
+	}
+	return ev
+}
+
+// ErrorKind returns the kind of the error, GenericError if it has none.
+func ErrorKind(err *Error) Type {
+	if err == nil || err.Kind == "" {
+		return GenericError
+	}
+	return err.Kind
+}
+
+// Is reports whether the error matches target for errors.Is. An *Error
+// target with only a Kind matches all errors of that kind, otherwise
+// the cause of the error is checked.
+func (ev *Error) Is(target error) bool {
+	if ev == nil {
+		return false
+	}
+	if te, ok := target.(*Error); ok && te != nil && te.Kind != "" &&
+		te.Message == "" && te.Kind == ErrorKind(ev) {
+		return true
+	}
+	return ev.Cause != nil && errors.Is(ev.Cause, target)
+}
+
+// As finds the first error in the cause chain that matches target
+// for errors.As.
+func (ev *Error) As(target interface{}) bool {
+	return ev != nil && ev.Cause != nil && errors.As(ev.Cause, target)
+}
+
+// causeError is an *Error with an Unwrap method like the ones of the
+// errors package, which returns the cause of the error.
+type causeError struct {
+	err *Error
+}
+
+func (ce causeError) Error() string {
+	return ce.err.Error()
+}
+
+func (ce causeError) Unwrap() error {
+	return ce.err.Cause
+}
+
+func (ce causeError) Is(target error) bool {
+	return ce.err == target || ce.err.Is(target)
+}
+
+// Err returns the error as an error that errors.Unwrap unwraps to the
+// cause of the error. It returns nil if the error is nil.
+func (ev *Error) Err() error {
+	if ev == nil {
+		return nil
+	}
+	return causeError{ev}
+}
+
+// IsKind returns true if err is or was caused by an *Error of the kind.
+func IsKind(err error, kind Type) bool {
+	return errors.Is(err, &Error{Kind: kind})
+}
+
+func (env *Environment) FailKind(kind Type, msg string, args ...Value) (Value, Effect) {
+	return env.Fail(env.ErrorFromString(msg, args...).WithKind(kind))
+}
+
+func ekind(env *Environment, args ...Value) (Value, Effect) {
+	var err *Error
+	erra := Args(args, &err)
+	if erra != nil {
+		return env.Fail(erra)
+	}
+	return ErrorKind(err), nil
+}
+
+func epayload(env *Environment, args ...Value) (Value, Effect) {
+	var err *Error
+	erra := Args(args, &err)
+	if erra != nil {
+		return env.Fail(erra)
+	}
+	return err.Payload, nil
+}
+
+func emessage(env *Environment, args ...Value) (Value, Effect) {
+	var err *Error
+	erra := Args(args, &err)
+	if erra != nil {
+		return env.Fail(erra)
+	}
+	return String(err.Message), nil
+}
+
+func ecause(env *Environment, args ...Value) (Value, Effect) {
+	var err *Error
+	erra := Args(args, &err)
+	if erra != nil {
+		return env.Fail(erra)
+	}
+	if err.Cause == nil {
+		return nil, nil
+	}
+	if cause, ok := err.Cause.(*Error); ok {
+		return cause, nil
+	}
+	return ErrorFromError(err.Cause), nil
+}
@@ -0,0 +1,63 @@
+package tgtl
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestErrorKinds(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`print "$1" [try { fail "boom" } catch e { ekind $e }]`,
+			"UserError", false},
+		sTestCase{`print "$1" [try { fail $IndexError "bad index $1" 7 } catch e { list [ekind $e] [emessage $e] [epayload $e] }]`,
+			"[list IndexError bad index 7 7]", false},
+		sTestCase{`print "$1" [try { idiv 1 0 } catch e ArithmeticError { ekind $e }]`,
+			"ArithmeticError", false},
+		sTestCase{`print "$1" [try { lget [list 1] 5 } catch e IndexError { ekind $e }]`,
+			"IndexError", false},
+		sTestCase{`print "$1" [try { iadd "a" 1 } catch e TypeError { ekind $e }]`,
+			"TypeError", false},
+		sTestCase{`print "$1" [try { iadd 1 } catch e ArgumentError { ekind $e }]`,
+			"ArgumentError", false},
+		sTestCase{`to f { f; nop }; print "$1" [try { f } catch e QuotaExceeded { ekind $e }]`,
+			"QuotaExceeded", false},
+		sTestCase{`print "$1" [try { fail [type Custom] "mine" } catch e Custom { ekind $e }]`,
+			"Custom", false},
+		sTestCase{`try { fail $TypeError "not caught" } catch e IndexError { print "wrong" }`,
+			"", true},
+	})
+}
+
+func TestErrorIsAs(t *testing.T) {
+	env, _ := newTestEnvironment()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	env.Context = ctx
+	err := env.Canceled()
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("error: cause not found by errors.Is: %v", err)
+	}
+	if !IsKind(err, QuotaExceeded) || IsKind(err, TypeError) {
+		t.Errorf("error: kind not as expected: %v", ErrorKind(err))
+	}
+	cause := ErrorWithKind(IndexError, "inner")
+	cause.Payload = Int(3)
+	wrapped := ErrorFromError(cause)
+	var found *Error
+	if !errors.As(error(wrapped), &found) || found.Payload != Int(3) {
+		t.Errorf("error: errors.As did not find the error")
+	}
+	if ErrorKind(wrapped) != IndexError || !errors.Is(wrapped, cause) {
+		t.Errorf("error: wrapped error lost it's cause or kind")
+	}
+	if errors.Unwrap(err.Err()) != context.Canceled || !errors.Is(err.Err(), err) {
+		t.Errorf("error: Err does not unwrap to the cause")
+	}
+	if !IsKind(err.Err(), QuotaExceeded) {
+		t.Errorf("error: Err lost the kind of the error")
+	}
+	if ErrorKind(ErrorFromString("plain")) != GenericError {
+		t.Errorf("error: plain errors should be of the generic kind")
+	}
+}
@@ -0,0 +1,203 @@
+package tgtl
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// ExecPolicy configures which programs the exec builtins may run,
+// and how.
+type ExecPolicy struct {
+	// Allow lists the programs that may be executed, by their name
+	// or path exactly as they are used in the script.
+	// If empty, no programs may be executed.
+	Allow []string
+	// Dir is the default working directory for the programs.
+	// If empty, the working directory of the host process is used.
+	Dir string
+	// Env are extra environment variables, in "KEY=value" form,
+	// for all programs.
+	Env []string
+	// InheritEnv passes the environment variables of the host process
+	// to the programs if true.
+	InheritEnv bool
+	// Timeout limits how long a program may run, if not zero.
+	// The program is also stopped if the Context of the
+	// Environment is canceled.
+	Timeout time.Duration
+}
+
+// Allowed returns true if the policy allows the program to be executed.
+func (policy *ExecPolicy) Allowed(program string) bool {
+	if policy == nil {
+		return false
+	}
+	for _, allow := range policy.Allow {
+		if allow == program {
+			return true
+		}
+	}
+	return false
+}
+
+// ExecOptions are the options for a single execution of a program.
+// In TGTL they are passed as a map or dict to exec_with, with the keys
+// dir, env, stdin, timeout in milliseconds and stream.
+type ExecOptions struct {
+	Dir     string
+	Env     []string
+	Stdin   string
+	Timeout time.Duration
+	// Stream sends the output of the program to the writers
+	// of the environment in stead of collecting it.
+	Stream bool
+}
+
+// ExecOptionsFromMapper converts a map or dict to ExecOptions.
+func ExecOptionsFromMapper(m Mapper) (ExecOptions, *Error) {
+	opts := ExecOptions{}
+	var err *Error
+	for _, k := range m.Keys() {
+		v, _ := m.Get(k)
+		switch k.String() {
+		case "dir":
+			err = Convert(v, &opts.Dir)
+		case "stdin":
+			err = Convert(v, &opts.Stdin)
+		case "stream":
+			err = Convert(v, &opts.Stream)
+		case "timeout":
+			var ms int
+			err = Convert(v, &ms)
+			opts.Timeout = time.Duration(ms) * time.Millisecond
+		case "env":
+			var vars Mapper
+			err = Convert(v, &vars)
+			if err == nil {
+				for _, name := range OrderedKeys(vars) {
+					val, _ := vars.Get(name)
+					opts.Env = append(opts.Env, name.String()+"="+val.String())
+				}
+			}
+		default:
+			err = ErrorFromString("Unknown exec option: " + k.String())
+		}
+		if err != nil {
+			return opts, err
+		}
+	}
+	return opts, nil
+}
+
+// Exec executes the program with the given arguments if the policy of
+// the environment allows it. It returns the exit code of the program,
+// or an error if it could not be started or did not complete.
+func (env *Environment) Exec(opts ExecOptions, stdout, stderr io.Writer, program string, args ...string) (int, *Error) {
+	policy := env.ExecPolicy
+	if !policy.Allowed(program) {
+		return -1, ErrorFromString("exec: program not allowed: " + program)
+	}
+	ctx := env.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	for _, timeout := range []time.Duration{policy.Timeout, opts.Timeout} {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
+	cmd := exec.CommandContext(ctx, program, args...)
+	cmd.Dir = policy.Dir
+	if opts.Dir != "" {
+		cmd.Dir = opts.Dir
+	}
+	cmd.Env = []string{}
+	if policy.InheritEnv {
+		cmd.Env = append(cmd.Env, os.Environ()...)
+	}
+	cmd.Env = append(cmd.Env, policy.Env...)
+	cmd.Env = append(cmd.Env, opts.Env...)
+	cmd.Stdin = bytes.NewBufferString(opts.Stdin)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return -1, ErrorFromString("exec: " + program + ": " + ctx.Err().Error())
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitErr.ExitCode(), nil
+	} else if err != nil {
+		return -1, ErrorFromError(err)
+	}
+	return 0, nil
+}
+
+func execute(env *Environment, opts ExecOptions, args ...Value) (Value, Effect) {
+	var program string
+	err := Args(args, &program)
+	if err != nil {
+		return env.Fail(err)
+	}
+	params := List(args[1:]).ToStrings()
+	if opts.Stream {
+		stdout, stderr := env.Writer(), env.ErrWriter()
+		if stdout == nil {
+			return env.FailString("exec: no writer set in environment")
+		}
+		if stderr == nil {
+			stderr = stdout
+		}
+		code, err := env.Exec(opts, stdout, stderr, program, params...)
+		if err != nil {
+			return env.Fail(err)
+		}
+		return Int(code), nil
+	}
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code, err := env.Exec(opts, stdout, stderr, program, params...)
+	if err != nil {
+		return env.Fail(err)
+	}
+	res, _ := NewDict(
+		String("stdout"), String(stdout.String()),
+		String("stderr"), String(stderr.String()),
+		String("code"), Int(code),
+	)
+	return res, nil
+}
+
+func exec_(env *Environment, args ...Value) (Value, Effect) {
+	return execute(env, ExecOptions{}, args...)
+}
+
+func execStream(env *Environment, args ...Value) (Value, Effect) {
+	return execute(env, ExecOptions{Stream: true}, args...)
+}
+
+func execWith(env *Environment, args ...Value) (Value, Effect) {
+	var options Mapper
+	err := Args(args, &options)
+	if err != nil {
+		return env.Fail(err)
+	}
+	opts, err := ExecOptionsFromMapper(options)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return execute(env, opts, args[1:]...)
+}
+
+// RegisterExecBuiltins registers the builtins that execute programs.
+// The ExecPolicy of the environment must be set and allow the
+// programs before they can be executed.
+func (env *Environment) RegisterExecBuiltins() {
+	env.Register("exec", exec_, "executes program $1 with the other arguments and returns a dict with stdout, stderr and the exit code")
+	env.Register("exec_stream", execStream, "executes program $1 with the other arguments, writes it's output to the current writers and returns the exit code")
+	env.Register("exec_with", execWith, "executes program $2 like exec, with the options map $1 with dir, env, stdin, timeout in ms and stream")
+}
@@ -0,0 +1,44 @@
+package tgtl
+
+import "testing"
+import "context"
+import "time"
+import "os/exec"
+
+func TestExecBuiltins(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skipf("No shell available: %v", err)
+	}
+	env, out := newTestEnvironment()
+	env.ExecPolicy = &ExecPolicy{Allow: []string{"sh"}}
+	env.RegisterExecBuiltins()
+	script := `
+let r [exec sh "-c" "echo out; echo err >&2; exit 3"]
+print "$1|$2|$3|" [mget $r stdout] [mget $r stderr] [mget $r code]
+print "$1|" [mget [exec_with [map stdin "in" env [map V v]] sh "-c" "cat; echo $V"] stdout]
+print "$1" [exec_stream sh "-c" "echo streamed"]
+`
+	parsed, perr := Parse(script)
+	if perr != nil {
+		t.Fatalf("error: unexpected parse error: %v", perr)
+	}
+	_, eff := parsed.Eval(env)
+	if eff != nil {
+		t.Fatalf("error: unexpected effect: %v", eff)
+	}
+	expect := "out\n|err\n|3|inv\n|streamed\n0"
+	if out.String() != expect {
+		t.Errorf("error: output not as expected: %q <-> %q", out.String(), expect)
+	}
+	parsed, _ = Parse("exec ls\n")
+	if _, eff = parsed.Eval(env); eff == nil {
+		t.Errorf("error: expected failure for program that is not allowed")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	env.Context = ctx
+	parsed, _ = Parse("exec sh \"-c\" \"exec sleep 5\"\n")
+	if _, eff = parsed.Eval(env); eff == nil {
+		t.Errorf("error: expected failure for program that timed out")
+	}
+}
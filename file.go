@@ -0,0 +1,353 @@
+package tgtl
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// FileSystem is the interface through which the file builtins access
+// files. Paths are slash separated and relative to the root of the
+// file system. Embedders can implement it to decide exactly which
+// files a script can reach.
+type FileSystem interface {
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte) error
+	AppendFile(name string, data []byte) error
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Remove(name string) error
+	Mkdir(name string) error
+}
+
+// DirFS is a FileSystem that is jailed in a root directory
+// of the operating system's file system.
+type DirFS struct {
+	// Root is the directory that contains all reachable files.
+	Root string
+	// ReadOnly disallows all modifications if true.
+	ReadOnly bool
+	// Allow is a list of path.Match patterns, relative to Root.
+	// If not empty, only the paths that match one of the patterns,
+	// or that are inside a directory that matches, can be accessed.
+	Allow []string
+}
+
+// NewDirFS returns a DirFS jailed in the given root directory.
+func NewDirFS(root string) *DirFS {
+	return &DirFS{Root: root}
+}
+
+func fsError(op, name, msg string) error {
+	return &os.PathError{Op: op, Path: name, Err: ErrorFromString(msg)}
+}
+
+// allowed returns true if the clean relative name is allowed.
+func (dfs *DirFS) allowed(name string) bool {
+	if len(dfs.Allow) == 0 {
+		return true
+	}
+	for check := name; ; check = path.Dir(check) {
+		for _, pattern := range dfs.Allow {
+			if ok, _ := path.Match(pattern, check); ok {
+				return true
+			}
+		}
+		if check == "." || check == "/" {
+			return false
+		}
+	}
+}
+
+// resolve returns the operating system path for the name,
+// or an error if the name is outside the jail or not allowed.
+func (dfs *DirFS) resolve(op, name string, write bool) (string, error) {
+	if write && dfs.ReadOnly {
+		return "", fsError(op, name, "file system is read only")
+	}
+	clean := path.Clean("/" + name)[1:]
+	if clean == "" {
+		clean = "."
+	}
+	if !dfs.allowed(clean) {
+		return "", fsError(op, name, "access not allowed")
+	}
+	root, err := filepath.Abs(dfs.Root)
+	if err != nil {
+		return "", err
+	}
+	full := filepath.Join(root, filepath.FromSlash(clean))
+	// Do not allow symbolic links to escape the jail. For paths that
+	// do not exist yet, check the nearest ancestor that does exist,
+	// since that is where they will be created.
+	existing := full
+	real, err := filepath.EvalSymlinks(existing)
+	for os.IsNotExist(err) {
+		if _, lerr := os.Lstat(existing); lerr == nil {
+			// A dangling symbolic link could point anywhere.
+			return "", fsError(op, name, "path is a dangling symbolic link")
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+		real, err = filepath.EvalSymlinks(existing)
+	}
+	if err != nil {
+		return "", err
+	}
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", err
+	}
+	if real != realRoot &&
+		!strings.HasPrefix(real, realRoot+string(filepath.Separator)) {
+		return "", fsError(op, name, "path escapes the root directory")
+	}
+	return full, nil
+}
+
+func (dfs *DirFS) ReadFile(name string) ([]byte, error) {
+	full, err := dfs.resolve("read", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return os.ReadFile(full)
+}
+
+func (dfs *DirFS) WriteFile(name string, data []byte) error {
+	full, err := dfs.resolve("write", name, true)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(full, data, 0666)
+}
+
+func (dfs *DirFS) AppendFile(name string, data []byte) error {
+	full, err := dfs.resolve("append", name, true)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(full, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (dfs *DirFS) Stat(name string) (os.FileInfo, error) {
+	full, err := dfs.resolve("stat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return os.Stat(full)
+}
+
+func (dfs *DirFS) ReadDir(name string) ([]os.FileInfo, error) {
+	full, err := dfs.resolve("readdir", name, false)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(full)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (dfs *DirFS) Remove(name string) error {
+	full, err := dfs.resolve("remove", name, true)
+	if err != nil {
+		return err
+	}
+	return os.Remove(full)
+}
+
+func (dfs *DirFS) Mkdir(name string) error {
+	full, err := dfs.resolve("mkdir", name, true)
+	if err != nil {
+		return err
+	}
+	return os.MkdirAll(full, 0777)
+}
+
+func fileSystem(env *Environment) (FileSystem, *Error) {
+	if env.FS == nil {
+		return nil, ErrorFromString("no file system set in environment")
+	}
+	return env.FS, nil
+}
+
+func fread(env *Environment, args ...Value) (Value, Effect) {
+	var name string
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	fs, err := fileSystem(env)
+	if err != nil {
+		return env.Fail(err)
+	}
+	buf, ferr := fs.ReadFile(name)
+	if ferr != nil {
+		return env.Fail(ErrorFromError(ferr))
+	}
+	return String(buf), nil
+}
+
+func fwrite(env *Environment, args ...Value) (Value, Effect) {
+	var name, data string
+	err := Args(args, &name, &data)
+	if err != nil {
+		return env.Fail(err)
+	}
+	fs, err := fileSystem(env)
+	if err != nil {
+		return env.Fail(err)
+	}
+	ferr := fs.WriteFile(name, []byte(data))
+	if ferr != nil {
+		return env.Fail(ErrorFromError(ferr))
+	}
+	return Int(len(data)), nil
+}
+
+func fappend(env *Environment, args ...Value) (Value, Effect) {
+	var name, data string
+	err := Args(args, &name, &data)
+	if err != nil {
+		return env.Fail(err)
+	}
+	fs, err := fileSystem(env)
+	if err != nil {
+		return env.Fail(err)
+	}
+	ferr := fs.AppendFile(name, []byte(data))
+	if ferr != nil {
+		return env.Fail(ErrorFromError(ferr))
+	}
+	return Int(len(data)), nil
+}
+
+func fexists(env *Environment, args ...Value) (Value, Effect) {
+	var name string
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	fs, err := fileSystem(env)
+	if err != nil {
+		return env.Fail(err)
+	}
+	_, ferr := fs.Stat(name)
+	return Bool(ferr == nil), nil
+}
+
+func flist(env *Environment, args ...Value) (Value, Effect) {
+	name := "."
+	if len(args) > 0 {
+		err := Args(args, &name)
+		if err != nil {
+			return env.Fail(err)
+		}
+	}
+	fs, err := fileSystem(env)
+	if err != nil {
+		return env.Fail(err)
+	}
+	infos, ferr := fs.ReadDir(name)
+	if ferr != nil {
+		return env.Fail(ErrorFromError(ferr))
+	}
+	res := List{}
+	for _, info := range infos {
+		res = append(res, String(info.Name()))
+	}
+	return res.SortStrings(), nil
+}
+
+func fremove(env *Environment, args ...Value) (Value, Effect) {
+	var name string
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	fs, err := fileSystem(env)
+	if err != nil {
+		return env.Fail(err)
+	}
+	ferr := fs.Remove(name)
+	if ferr != nil {
+		return env.Fail(ErrorFromError(ferr))
+	}
+	return nil, nil
+}
+
+func mkdir(env *Environment, args ...Value) (Value, Effect) {
+	var name string
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	fs, err := fileSystem(env)
+	if err != nil {
+		return env.Fail(err)
+	}
+	ferr := fs.Mkdir(name)
+	if ferr != nil {
+		return env.Fail(ErrorFromError(ferr))
+	}
+	return nil, nil
+}
+
+func stat(env *Environment, args ...Value) (Value, Effect) {
+	var name string
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	fs, err := fileSystem(env)
+	if err != nil {
+		return env.Fail(err)
+	}
+	info, ferr := fs.Stat(name)
+	if ferr != nil {
+		return env.Fail(ErrorFromError(ferr))
+	}
+	res, _ := NewDict(
+		String("name"), String(info.Name()),
+		String("size"), Int(info.Size()),
+		String("dir"), Bool(info.IsDir()),
+		String("mode"), Int(info.Mode().Perm()),
+		String("modified"), Int(info.ModTime().Unix()),
+	)
+	return res, nil
+}
+
+// RegisterFileBuiltins registers the builtins that access files
+// through the FS of the environment. The FS must be set before
+// these builtins can be used.
+func (env *Environment) RegisterFileBuiltins() {
+	env.Register("fread", fread, "reads the file named $1 as a String")
+	env.Register("fwrite", fwrite, "writes the String $2 to the file named $1")
+	env.Register("fappend", fappend, "appends the String $2 to the file named $1")
+	env.Register("fexists", fexists, "returns true if the file named $1 exists")
+	env.Register("flist", flist, "returns a sorted list of the names of the files in directory $1")
+	env.Register("fremove", fremove, "removes the file or empty directory named $1")
+	env.Register("mkdir", mkdir, "creates the directory named $1 and its parents")
+	env.Register("stat", stat, "returns a dict with the name, size, dir, mode and modified time of file $1")
+}
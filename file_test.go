@@ -0,0 +1,99 @@
+package tgtl
+
+import "testing"
+import "os"
+import "path/filepath"
+
+func TestDirFS(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	dfs := NewDirFS(root)
+	if err := dfs.WriteFile("../escape.txt", []byte("x")); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(root, "escape.txt")); err != nil {
+		t.Errorf("../ should be jailed in the root: %v", err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "link")); err != nil {
+		t.Skipf("Cannot create symlink: %v", err)
+	}
+	if err := dfs.WriteFile("link/file.txt", []byte("x")); err == nil {
+		t.Errorf("Expected error writing through symlink out of the jail")
+	}
+	if err := dfs.Mkdir("link/a/b"); err == nil {
+		t.Errorf("Expected error creating directories through symlink out of the jail")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "a")); err == nil {
+		t.Errorf("Directory was created outside of the jail")
+	}
+	if err := os.Symlink(filepath.Join(outside, "new.txt"), filepath.Join(root, "dangling")); err != nil {
+		t.Fatalf("Cannot create symlink: %v", err)
+	}
+	if err := dfs.WriteFile("dangling", []byte("x")); err == nil {
+		t.Errorf("Expected error writing through dangling symlink")
+	}
+	if _, err := os.Stat(filepath.Join(outside, "new.txt")); err == nil {
+		t.Errorf("File was created outside of the jail")
+	}
+	dfs.ReadOnly = true
+	if err := dfs.WriteFile("ro.txt", []byte("x")); err == nil {
+		t.Errorf("Expected error writing to read only file system")
+	}
+	dfs.ReadOnly = false
+	dfs.Allow = []string{"data"}
+	if err := dfs.Mkdir("data/sub"); err != nil {
+		t.Errorf("Unexpected error: %v", err)
+	}
+	if _, err := dfs.ReadFile("escape.txt"); err == nil {
+		t.Errorf("Expected error reading file that is not allowed")
+	}
+}
+
+func TestFileBuiltins(t *testing.T) {
+	env, out := newTestEnvironment()
+	env.FS = NewDirFS(t.TempDir())
+	env.RegisterFileBuiltins()
+	script := `
+fwrite "a.txt" "hello"
+fappend "a.txt" " world"
+mkdir "dir/sub"
+print "$1|$2|$3|" [fread "a.txt"] [fexists "b.txt"] [flist "."]
+print "$1|" [mget [stat "a.txt"] size]
+fremove "a.txt"
+print "$1" [fexists "a.txt"]
+`
+	parsed, perr := Parse(script)
+	if perr != nil {
+		t.Fatalf("error: unexpected parse error: %v", perr)
+	}
+	_, eff := parsed.Eval(env)
+	if eff != nil {
+		t.Fatalf("error: unexpected effect: %v", eff)
+	}
+	expect := "hello world|false|[list a.txt dir]|11|false"
+	if out.String() != expect {
+		t.Errorf("error: output not as expected: %q <-> %q", out.String(), expect)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	dfs := NewDirFS(t.TempDir())
+	dfs.Mkdir("a/x")
+	dfs.Mkdir("b/y")
+	dfs.WriteFile("a/x/1.txt", nil)
+	dfs.WriteFile("b/2.txt", nil)
+	dfs.WriteFile("b/3.go", nil)
+	res, err := Glob(dfs, "*/*.txt")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := StringList("b/2.txt")
+	if !Equal(res, expect) {
+		t.Errorf("Not equal: %v<->%v", res, expect)
+	}
+	res, _ = Glob(dfs, "*/*/*")
+	expect = StringList("a/x/1.txt")
+	if !Equal(res, expect) {
+		t.Errorf("Not equal: %v<->%v", res, expect)
+	}
+}
@@ -0,0 +1,237 @@
+package tgtl
+
+import "reflect"
+
+// Frozen is a read only wrapper around a value, normally a List, Map or
+// *Dict. Frozen values can be read like the value they wrap, but the
+// builtins that modify collections in place refuse to modify them.
+type Frozen struct {
+	Value
+}
+
+// identity identifies a collection, so the deep copies can tell
+// which collections they already copied.
+type identity struct {
+	ptr uintptr
+	len int
+}
+
+// copies are the copies of the collections a deep copy already made.
+// A collection that contains itself, directly or not, is copied only
+// once, and the copy contains itself in the same way.
+type copies map[identity]Value
+
+// identify returns the identity of a list, map or dict, and false for
+// other values and for nil collections, which cannot contain themselves.
+func identify(val Value) (identity, bool) {
+	switch cv := val.(type) {
+	case List:
+		// Lists that share elements are different if their lengths are.
+		ptr := reflect.ValueOf(cv).Pointer()
+		return identity{ptr, len(cv)}, ptr != 0
+	case Map, *Dict:
+		ptr := reflect.ValueOf(cv).Pointer()
+		return identity{ptr, 0}, ptr != 0
+	default:
+		return identity{}, false
+	}
+}
+
+// Freeze returns a read only deep copy of the value.
+// Collections nested in the value are frozen as well.
+func Freeze(val Value) Value {
+	return freezeIn(val, copies{})
+}
+
+func freezeIn(val Value, seen copies) Value {
+	if _, ok := val.(Frozen); ok {
+		return val
+	}
+	id, ok := identify(val)
+	if res, found := seen[id]; ok && found {
+		return res
+	}
+	switch fv := val.(type) {
+	case List:
+		res := make(List, len(fv))
+		seen[id] = Frozen{res}
+		for i, v := range fv {
+			res[i] = freezeIn(v, seen)
+		}
+		return Frozen{res}
+	case Map:
+		res := make(Map, len(fv))
+		seen[id] = Frozen{res}
+		for k, v := range fv {
+			res[k] = freezeIn(v, seen)
+		}
+		return Frozen{res}
+	case *Dict:
+		res, _ := NewDict()
+		seen[id] = Frozen{res}
+		for _, k := range fv.Keys() {
+			v, _ := fv.Get(k)
+			res.Set(k, freezeIn(v, seen))
+		}
+		return Frozen{res}
+	default:
+		return val
+	}
+}
+
+// IsFrozen returns true if the value is read only.
+func IsFrozen(val Value) bool {
+	_, ok := val.(Frozen)
+	return ok
+}
+
+// Unfrozen returns the value wrapped by a Frozen value,
+// or the value itself if it is not frozen.
+// The returned value should not be modified.
+func Unfrozen(val Value) Value {
+	if fv, ok := val.(Frozen); ok {
+		return fv.Value
+	}
+	return val
+}
+
+// Copy returns a shallow copy of a List, Map, *Dict or Overload that
+// can be modified without affecting the original.
+// Frozen values are copied to a modifiable value.
+// Other values are returned as is.
+func Copy(val Value) Value {
+	switch cv := Unfrozen(val).(type) {
+	case List:
+		res := make(List, len(cv))
+		copy(res, cv)
+		return res
+	case Map:
+		res := make(Map, len(cv))
+		for k, v := range cv {
+			res[k] = v
+		}
+		return res
+	case *Dict:
+		res, _ := NewDict()
+		for _, k := range cv.Keys() {
+			v, _ := cv.Get(k)
+			res.Set(k, v)
+		}
+		return res
+	case Overload:
+		res := make(Overload, len(cv))
+		for k, v := range cv {
+			res[k] = v
+		}
+		return res
+	default:
+		return cv
+	}
+}
+
+// DeepCopy returns a copy of the value where all nested
+// collections are copied as well, and none of them are frozen.
+func DeepCopy(val Value) Value {
+	return deepCopyIn(val, copies{})
+}
+
+func deepCopyIn(val Value, seen copies) Value {
+	id, ok := identify(Unfrozen(val))
+	if res, found := seen[id]; ok && found {
+		return res
+	}
+	res := Copy(val)
+	if ok {
+		seen[id] = res
+	}
+	switch cv := res.(type) {
+	case List:
+		for i, v := range cv {
+			cv[i] = deepCopyIn(v, seen)
+		}
+		return cv
+	case Map:
+		for k, v := range cv {
+			cv[k] = deepCopyIn(v, seen)
+		}
+		return cv
+	case *Dict:
+		for _, k := range cv.Keys() {
+			v, _ := cv.Get(k)
+			cv.Set(k, deepCopyIn(v, seen))
+		}
+		return cv
+	default:
+		return cv
+	}
+}
+
+// isolate returns a copy of the value for use in another environment,
+// or by the variable or procedure it is bound or passed to.
+// Like DeepCopy, nested collections are copied as well, but frozen
+// values are read only, so they are shared as they are and stay frozen.
+func isolate(val Value) Value {
+	return isolateIn(val, copies{})
+}
+
+func isolateIn(val Value, seen copies) Value {
+	id, ok := identify(val)
+	if res, found := seen[id]; ok && found {
+		return res
+	}
+	switch cv := val.(type) {
+	case List:
+		res := make(List, len(cv))
+		seen[id] = res
+		for i, v := range cv {
+			res[i] = isolateIn(v, seen)
+		}
+		return res
+	case Map:
+		res := make(Map, len(cv))
+		seen[id] = res
+		for k, v := range cv {
+			res[k] = isolateIn(v, seen)
+		}
+		return res
+	case *Dict:
+		res, _ := NewDict()
+		seen[id] = res
+		for _, k := range cv.Keys() {
+			v, _ := cv.Get(k)
+			res.Set(k, isolateIn(v, seen))
+		}
+		return res
+	case Overload:
+		return Copy(cv)
+	default:
+		return val
+	}
+}
+
+// Mutable returns an error if the value is frozen and may not be modified.
+// Builtins that modify values in place should check this first.
+func Mutable(val Value) *Error {
+	if IsFrozen(val) {
+		return ErrorFromString("Cannot modify frozen " + TypeOf(val).String())
+	}
+	return nil
+}
+
+func (fv Frozen) Eval(env *Environment, args ...Value) (Value, Effect) {
+	return fv, nil
+}
+
+func (fv Frozen) Type() Type {
+	return TypeOf(fv.Value)
+}
+
+// Convert converts the wrapped value, except that conversion to
+// a Value keeps the value frozen.
+func (fv Frozen) Convert(to interface{}) *Error {
+	if toPtr, ok := to.(*Value); ok {
+		(*toPtr) = fv
+		return nil
+	}
+	return Convert(fv.Value, to)
+}
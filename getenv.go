@@ -0,0 +1,65 @@
+package tgtl
+
+import (
+	"os"
+	"strings"
+)
+
+func getenv(env *Environment, args ...Value) (Value, Effect) {
+	var name string
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	val, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, nil
+	}
+	return String(val), nil
+}
+
+func setenv(env *Environment, args ...Value) (Value, Effect) {
+	var name, val string
+	err := Args(args, &name, &val)
+	if err != nil {
+		return env.Fail(err)
+	}
+	serr := os.Setenv(name, val)
+	if serr != nil {
+		return env.Fail(ErrorFromError(serr))
+	}
+	return String(val), nil
+}
+
+func unsetenv(env *Environment, args ...Value) (Value, Effect) {
+	var name string
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	serr := os.Unsetenv(name)
+	if serr != nil {
+		return env.Fail(ErrorFromError(serr))
+	}
+	return nil, nil
+}
+
+func environ(env *Environment, args ...Value) (Value, Effect) {
+	res := make(Map)
+	for _, kv := range os.Environ() {
+		if i := strings.Index(kv, "="); i > 0 {
+			res[kv[0:i]] = String(kv[i+1:])
+		}
+	}
+	return res, nil
+}
+
+// RegisterEnvBuiltins registers the builtins that access the
+// environment variables of the host process.
+// Only register these if scripts may read and modify them.
+func (env *Environment) RegisterEnvBuiltins() {
+	env.Register("getenv", getenv, "returns the environment variable $1, or nil if it is not set")
+	env.Register("setenv", setenv, "sets the environment variable $1 to $2")
+	env.Register("unsetenv", unsetenv, "removes the environment variable $1")
+	env.Register("environ", environ, "returns all environment variables as a map")
+}
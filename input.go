@@ -0,0 +1,173 @@
+package tgtl
+
+import (
+	"bufio"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// BufferedReader returns a buffered reader that reads from the
+// current reader of the environment. The first time this is called
+// for a given reader, the reader is replaced by the buffered reader
+// in the environment and in all frames that use it, so no buffered
+// input is lost between reads.
+// Returns nil if no reader is set.
+func (env *Environment) BufferedReader() *bufio.Reader {
+	in := env.Reader()
+	if in == nil {
+		return nil
+	}
+	if buffered, ok := in.(*bufio.Reader); ok {
+		return buffered
+	}
+	buffered := bufio.NewReader(in)
+	if sameReader(env.In, in) {
+		env.In = buffered
+	}
+	for _, frame := range env.Frames {
+		if sameReader(frame.In, in) {
+			frame.In = buffered
+		}
+	}
+	return buffered
+}
+
+// sameReader returns true if a is the reader b. Comparing readers with
+// == panics if their type is not comparable. Such readers are the same
+// if their types are, since the readers of the frames are all copied
+// from the reader of the environment.
+func sameReader(a, b Reader) bool {
+	if a == nil || b == nil {
+		return false
+	}
+	ta := reflect.TypeOf(a)
+	if ta != reflect.TypeOf(b) {
+		return false
+	}
+	return !ta.Comparable() || a == b
+}
+
+// ReadLine reads a line from the environment's reader,
+// without the line terminator. Returns io.EOF if no more
+// input is available.
+func (env *Environment) ReadLine() (string, error) {
+	in := env.BufferedReader()
+	if in == nil {
+		return "", env.ErrorFromString("no reader set in environment.")
+	}
+	line, err := in.ReadString('\n')
+	if err == io.EOF && line != "" {
+		err = nil
+	}
+	if err != nil {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return line, nil
+}
+
+func read(env *Environment, args ...Value) (Value, Effect) {
+	var size int
+	err := Args(args, &size)
+	if err != nil {
+		return env.Fail(err)
+	}
+	if size < 0 {
+		return env.FailString("read: size must not be negative")
+	}
+	in := env.BufferedReader()
+	if in == nil {
+		return env.FailString("read: no reader set in environment")
+	}
+	buf := make([]byte, size)
+	n, rerr := io.ReadFull(in, buf)
+	if rerr != nil && rerr != io.EOF && rerr != io.ErrUnexpectedEOF {
+		return env.Fail(ErrorFromError(rerr))
+	}
+	return String(buf[0:n]), nil
+}
+
+func readline(env *Environment, args ...Value) (Value, Effect) {
+	line, err := env.ReadLine()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return env.Fail(ErrorFromError(err))
+	}
+	return String(line), nil
+}
+
+func readall(env *Environment, args ...Value) (Value, Effect) {
+	in := env.BufferedReader()
+	if in == nil {
+		return env.FailString("readall: no reader set in environment")
+	}
+	buf := &strings.Builder{}
+	_, err := io.Copy(buf, in)
+	if err != nil {
+		return env.Fail(ErrorFromError(err))
+	}
+	return String(buf.String()), nil
+}
+
+func eof(env *Environment, args ...Value) (Value, Effect) {
+	in := env.BufferedReader()
+	if in == nil {
+		return Bool(true), nil
+	}
+	_, err := in.Peek(1)
+	return Bool(err != nil), nil
+}
+
+func lines(env *Environment, args ...Value) (Value, Effect) {
+	var name, label Word
+	var block Block
+	// lines ?name? {block} ?label?
+	named := len(args) > 1
+	if named {
+		_, isBlock := args[0].(Block)
+		named = !isBlock
+	}
+	if named {
+		err := Args(args, &name, &block)
+		if err != nil {
+			return env.Fail(err)
+		}
+		label, err = loopLabel(args, 2)
+		if err != nil {
+			return env.Fail(err)
+		}
+	} else {
+		err := Args(args, &block)
+		if err != nil {
+			return env.Fail(err)
+		}
+		label, err = loopLabel(args, 1)
+		if err != nil {
+			return env.Fail(err)
+		}
+	}
+	defer env.Label(label)()
+	count := 0
+	for {
+		line, err := env.ReadLine()
+		if err == io.EOF {
+			return Int(count), nil
+		} else if err != nil {
+			return env.Fail(ErrorFromError(err))
+		}
+		count++
+		if name != "" {
+			env.Define(name.String(), String(line), 0)
+		}
+		bval, beff := block.Eval(env, String(line))
+		bval, beff, done := env.LoopEffect(label, bval, beff)
+		if beff != nil {
+			return bval, beff
+		} else if done {
+			return Int(count), nil
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package tgtl
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// JSONOptions are the options for EncodeJSON.
+type JSONOptions struct {
+	// Indent is used to pretty print the JSON if it is not empty.
+	Indent string
+	// SortKeys sorts the keys of Dict values too.
+	// Map keys are always sorted, so the output is deterministic.
+	SortKeys bool
+}
+
+// EncodeJSON encodes a value as JSON. Maps and Dicts become objects,
+// Lists become arrays, String, Word and Type become strings,
+// Int becomes a number, Bool a boolean and nil becomes null.
+// Other values cannot be encoded.
+func EncodeJSON(val Value, opts JSONOptions) ([]byte, *Error) {
+	buf := &bytes.Buffer{}
+	err := encodeJSON(buf, val, opts, 0)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeJSONString(buf *bytes.Buffer, s string) *Error {
+	aid := &bytes.Buffer{}
+	enc := json.NewEncoder(aid)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return ErrorFromError(err)
+	}
+	buf.Write(bytes.TrimRight(aid.Bytes(), "\n"))
+	return nil
+}
+
+func encodeJSONNewline(buf *bytes.Buffer, opts JSONOptions, depth int) {
+	if opts.Indent != "" {
+		buf.WriteString("\n")
+		buf.WriteString(strings.Repeat(opts.Indent, depth))
+	}
+}
+
+func encodeJSONObject(buf *bytes.Buffer, m Mapper, keys List, opts JSONOptions, depth int) *Error {
+	if len(keys) == 0 {
+		buf.WriteString("{}")
+		return nil
+	}
+	buf.WriteString("{")
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		encodeJSONNewline(buf, opts, depth+1)
+		if err := encodeJSONString(buf, k.String()); err != nil {
+			return err
+		}
+		buf.WriteString(":")
+		if opts.Indent != "" {
+			buf.WriteString(" ")
+		}
+		v, _ := m.Get(k)
+		if err := encodeJSON(buf, v, opts, depth+1); err != nil {
+			return err
+		}
+	}
+	encodeJSONNewline(buf, opts, depth)
+	buf.WriteString("}")
+	return nil
+}
+
+func encodeJSON(buf *bytes.Buffer, val Value, opts JSONOptions, depth int) *Error {
+	switch jv := Unfrozen(val).(type) {
+	case nil:
+		buf.WriteString("null")
+	case Bool:
+		buf.WriteString(jv.String())
+	case Int:
+		buf.WriteString(jv.String())
+	case String:
+		return encodeJSONString(buf, string(jv))
+	case Word:
+		return encodeJSONString(buf, string(jv))
+	case Type:
+		return encodeJSONString(buf, string(jv))
+	case List:
+		if len(jv) == 0 {
+			buf.WriteString("[]")
+			return nil
+		}
+		buf.WriteString("[")
+		for i, v := range jv {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			encodeJSONNewline(buf, opts, depth+1)
+			if err := encodeJSON(buf, v, opts, depth+1); err != nil {
+				return err
+			}
+		}
+		encodeJSONNewline(buf, opts, depth)
+		buf.WriteString("]")
+	case Map:
+		return encodeJSONObject(buf, jv, jv.SortedKeys(), opts, depth)
+	case *Dict:
+		keys := jv.Keys()
+		if opts.SortKeys {
+			keys = jv.SortedKeys()
+		}
+		return encodeJSONObject(buf, jv, keys, opts, depth)
+	default:
+		return ErrorFromString("Cannot encode " + TypeOf(val).String() + " as JSON")
+	}
+	return nil
+}
+
+// DecodeJSON decodes JSON text to a value. Objects become a Map,
+// or a Dict that keeps the order of the keys if ordered is true.
+// Arrays become a List, strings a String, booleans a Bool, null nil,
+// and integer numbers an Int. Numbers with a fraction or exponent
+// are not supported. On failure, the Index of the returned
+// error is the offset in the JSON text where decoding failed.
+func DecodeJSON(data []byte, ordered bool) (Value, *Error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	val, err := decodeJSON(dec, ordered)
+	if err != nil {
+		return nil, err
+	}
+	if _, terr := dec.Token(); terr != io.EOF {
+		return nil, jsonError(dec, "unexpected data after JSON value", terr)
+	}
+	return val, nil
+}
+
+func jsonError(dec *json.Decoder, msg string, err error) *Error {
+	offset := int(dec.InputOffset())
+	if serr, ok := err.(*json.SyntaxError); ok {
+		// The error happened after reading Offset bytes,
+		// so the offending byte is the one before.
+		offset = int(serr.Offset) - 1
+		msg = serr.Error()
+	} else if err != nil && err != io.EOF {
+		msg = err.Error()
+	} else if err == io.EOF {
+		msg = "unexpected end of JSON input"
+	}
+	return NewError("JSON "+msg+" at offset "+Itoa(offset), offset)
+}
+
+func decodeJSON(dec *json.Decoder, ordered bool) (Value, *Error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, jsonError(dec, "", err)
+	}
+	switch jt := tok.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return Bool(jt), nil
+	case string:
+		return String(jt), nil
+	case json.Number:
+		i, err := strconv.Atoi(jt.String())
+		if err != nil {
+			return nil, jsonError(dec, "number is not an integer: "+jt.String(), nil)
+		}
+		return Int(i), nil
+	case json.Delim:
+		if jt == '[' {
+			res := List{}
+			for dec.More() {
+				val, err := decodeJSON(dec, ordered)
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, val)
+			}
+			_, err := dec.Token() // skip ]
+			if err != nil {
+				return nil, jsonError(dec, "", err)
+			}
+			return res, nil
+		}
+		var res Mapper = make(Map)
+		if ordered {
+			res, _ = NewDict()
+		}
+		for dec.More() {
+			key, err := dec.Token()
+			if err != nil {
+				return nil, jsonError(dec, "", err)
+			}
+			val, rerr := decodeJSON(dec, ordered)
+			if rerr != nil {
+				return nil, rerr
+			}
+			res.Set(String(key.(string)), val)
+		}
+		_, err := dec.Token() // skip }
+		if err != nil {
+			return nil, jsonError(dec, "", err)
+		}
+		return res, nil
+	}
+	return nil, jsonError(dec, "unexpected token", nil)
+}
+
+// Implement json.Marshaler and json.Unmarshaler for the collection values,
+// so embedders can persist script state with encoding/json.
+
+func (lv List) MarshalJSON() ([]byte, error) {
+	return marshalJSON(lv)
+}
+
+func (mv Map) MarshalJSON() ([]byte, error) {
+	return marshalJSON(mv)
+}
+
+func (d *Dict) MarshalJSON() ([]byte, error) {
+	return marshalJSON(d)
+}
+
+func (fv Frozen) MarshalJSON() ([]byte, error) {
+	return marshalJSON(fv)
+}
+
+func marshalJSON(val Value) ([]byte, error) {
+	buf, err := EncodeJSON(val, JSONOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (lv *List) UnmarshalJSON(data []byte) error {
+	val, err := DecodeJSON(data, false)
+	if err != nil {
+		return err
+	}
+	if err := Convert(val, lv); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (mv *Map) UnmarshalJSON(data []byte) error {
+	val, err := DecodeJSON(data, false)
+	if err != nil {
+		return err
+	}
+	if err := Convert(val, mv); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (d *Dict) UnmarshalJSON(data []byte) error {
+	val, err := DecodeJSON(data, true)
+	if err != nil {
+		return err
+	}
+	dict, ok := val.(*Dict)
+	if !ok {
+		return ErrorFromString("JSON value is not an object")
+	}
+	*d = *dict
+	return nil
+}
@@ -0,0 +1,61 @@
+package tgtl
+
+import "testing"
+import "encoding/json"
+
+func TestEncodeJSON(t *testing.T) {
+	dict, _ := NewDict(Word("z"), Int(1), Word("a"), List{Bool(true), nil})
+	val := Map{"b": String("x\"<"), "a": dict}
+	buf, err := EncodeJSON(val, JSONOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := `{"a":{"z":1,"a":[true,null]},"b":"x\"<"}`
+	if string(buf) != expect {
+		t.Errorf("Not equal: %s<->%s", buf, expect)
+	}
+	buf, err = EncodeJSON(List{Int(1), Map{}}, JSONOptions{Indent: " "})
+	expect = "[\n 1,\n {}\n]"
+	if string(buf) != expect {
+		t.Errorf("Not equal: %q<->%q", buf, expect)
+	}
+	_, err = EncodeJSON(Proc(nop), JSONOptions{})
+	if err == nil {
+		t.Errorf("Expected error for Proc")
+	}
+}
+
+func TestDecodeJSON(t *testing.T) {
+	val, err := DecodeJSON([]byte(`{"b": [1, "two", null, false], "a": {}}`), true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expect := "[dict b [list 1 two nil false] a [dict]]"
+	if val.String() != expect {
+		t.Errorf("Not equal: %s<->%s", val, expect)
+	}
+	_, err = DecodeJSON([]byte(`[1, 2.5]`), false)
+	if err == nil {
+		t.Errorf("Expected error for float")
+	}
+	_, err = DecodeJSON([]byte(`{"a": ]`), false)
+	if err == nil || err.Index != 6 {
+		t.Errorf("Expected error at offset 6: %v", err)
+	}
+}
+
+func TestMarshalJSON(t *testing.T) {
+	in := List{Int(7), Map{"k": String("v")}}
+	buf, err := json.Marshal(in)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	var out List
+	err = json.Unmarshal(buf, &out)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !Equal(in, out) {
+		t.Errorf("Not equal: %v<->%v", in, out)
+	}
+}
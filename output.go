@@ -0,0 +1,123 @@
+package tgtl
+
+import "strings"
+
+// Buffer is a Value that collects the output written to it,
+// for use with the redirect command.
+type Buffer struct {
+	strings.Builder
+}
+
+func (bv *Buffer) Eval(env *Environment, args ...Value) (Value, Effect) {
+	return bv, nil
+}
+
+func (*Buffer) Type() Type { return Type("Buffer") }
+
+func (from *Buffer) Convert(to interface{}) *Error {
+	switch toPtr := to.(type) {
+	case *string:
+		(*toPtr) = from.String()
+	case *String:
+		(*toPtr) = String(from.String())
+	case **Buffer:
+		(*toPtr) = from
+	case *Writer:
+		(*toPtr) = from
+	case *Value:
+		(*toPtr) = from
+	default:
+		return ErrorFromString("Cannot convert buffer value")
+	}
+	return nil
+}
+
+// discard is a Writer that discards all output.
+type discard struct{}
+
+func (discard) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Redirect evaluates the block with the output of the top frame, and
+// therefore of all nested commands, redirected to the given writer.
+func (env *Environment) Redirect(out Writer, block Block, args ...Value) (Value, Effect) {
+	frame := env.Top()
+	if frame == nil {
+		return env.FailString("no frame to redirect")
+	}
+	old := frame.Out
+	frame.Out = out
+	defer func() {
+		frame.Out = old
+	}()
+	return block.Eval(env, args...)
+}
+
+func eprint(env *Environment, args ...Value) (Value, Effect) {
+	var msg string
+	erra := Args(args, &msg)
+	if erra != nil {
+		return env.FailString("eprint: ${1}", erra)
+	}
+	msg = env.Interpolate(msg, args[1:]...)
+	n, err := env.WriteErr(msg)
+	if err == nil {
+		return Int(n), nil
+	}
+	return Int(n), ErrorFromError(err)
+}
+
+func buffer(env *Environment, args ...Value) (Value, Effect) {
+	buf := &Buffer{}
+	for _, arg := range args {
+		if arg != nil {
+			buf.WriteString(arg.String())
+		}
+	}
+	return buf, nil
+}
+
+func capture(env *Environment, args ...Value) (Value, Effect) {
+	var block Block
+	err := Args(args, &block)
+	if err != nil {
+		return env.Fail(err)
+	}
+	buf := &Buffer{}
+	val, eff := env.Redirect(buf, block, args[1:]...)
+	if eff != nil && eff.Flow() > NormalFlow {
+		return val, eff
+	}
+	return String(buf.String()), nil
+}
+
+func redirect(env *Environment, args ...Value) (Value, Effect) {
+	var target Value
+	var block Block
+	err := Args(args, &target, &block)
+	if err != nil {
+		return env.Fail(err)
+	}
+	var out Writer
+	if word, ok := target.(Word); ok {
+		switch word {
+		case "out", "stdout":
+			out = env.Out
+		case "err", "stderr":
+			out = env.ErrWriter()
+		case "null":
+			out = discard{}
+		default:
+			return env.FailString("redirect: unknown target ${1}", word)
+		}
+	} else if writer, ok := target.(Writer); ok {
+		out = writer
+	} else {
+		return env.FailString("redirect: cannot write to ${1}", target)
+	}
+	if out == nil {
+		return env.FailString("redirect: target ${1} has no writer", target)
+	}
+	return env.Redirect(out, block, args[2:]...)
+}
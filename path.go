@@ -0,0 +1,178 @@
+package tgtl
+
+import (
+	"path"
+	"strings"
+)
+
+// RelPath returns a slash separated path to target that is relative
+// to base. Both must either be absolute or relative.
+func RelPath(base, target string) (string, *Error) {
+	base, target = path.Clean(base), path.Clean(target)
+	if path.IsAbs(base) != path.IsAbs(target) {
+		return "", ErrorFromString("cannot make " + target + " relative to " + base)
+	}
+	split := func(p string) []string {
+		if p == "." || p == "/" {
+			return []string{}
+		}
+		return strings.Split(strings.TrimPrefix(p, "/"), "/")
+	}
+	bs, ts := split(base), split(target)
+	i := 0
+	for ; i < len(bs) && i < len(ts) && bs[i] == ts[i]; i++ {
+	}
+	parts := []string{}
+	for _, b := range bs[i:] {
+		if b == ".." {
+			return "", ErrorFromString("cannot make " + target + " relative to " + base)
+		}
+		parts = append(parts, "..")
+	}
+	parts = append(parts, ts[i:]...)
+	if len(parts) == 0 {
+		return ".", nil
+	}
+	return strings.Join(parts, "/"), nil
+}
+
+// Glob returns the sorted names of the files in the file system
+// that match the slash separated path.Match pattern.
+func Glob(fs FileSystem, pattern string) (List, *Error) {
+	pattern = path.Clean(pattern)
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, ErrorFromError(err)
+	}
+	found := []string{"."}
+	if strings.HasPrefix(pattern, "/") {
+		found = []string{"/"}
+	}
+	for _, part := range strings.Split(strings.TrimPrefix(pattern, "/"), "/") {
+		next := []string{}
+		for _, dir := range found {
+			infos, err := fs.ReadDir(dir)
+			if err != nil {
+				continue
+			}
+			for _, info := range infos {
+				if ok, _ := path.Match(part, info.Name()); ok {
+					next = append(next, path.Join(dir, info.Name()))
+				}
+			}
+		}
+		found = next
+	}
+	return StringList(found...).SortStrings(), nil
+}
+
+func pathJoin(env *Environment, args ...Value) (Value, Effect) {
+	parts := make([]string, len(args))
+	for i, arg := range args {
+		if arg == nil {
+			return env.FailKind(ArgumentError, "path_join: $"+Itoa(i+1)+" is nil")
+		}
+		parts[i] = arg.String()
+	}
+	return String(path.Join(parts...)), nil
+}
+
+func pathBase(env *Environment, args ...Value) (Value, Effect) {
+	var name string
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return String(path.Base(name)), nil
+}
+
+func pathDir(env *Environment, args ...Value) (Value, Effect) {
+	var name string
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return String(path.Dir(name)), nil
+}
+
+func pathExt(env *Environment, args ...Value) (Value, Effect) {
+	var name string
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return String(path.Ext(name)), nil
+}
+
+func pathClean(env *Environment, args ...Value) (Value, Effect) {
+	var name string
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return String(path.Clean(name)), nil
+}
+
+func pathRel(env *Environment, args ...Value) (Value, Effect) {
+	var base, target string
+	err := Args(args, &base, &target)
+	if err != nil {
+		return env.Fail(err)
+	}
+	rel, err := RelPath(base, target)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return String(rel), nil
+}
+
+func pathMatch(env *Environment, args ...Value) (Value, Effect) {
+	var pattern, name string
+	err := Args(args, &pattern, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	ok, merr := path.Match(pattern, name)
+	if merr != nil {
+		return env.Fail(ErrorFromError(merr))
+	}
+	return Bool(ok), nil
+}
+
+func glob(env *Environment, args ...Value) (Value, Effect) {
+	var pattern string
+	err := Args(args, &pattern)
+	if err != nil {
+		return env.Fail(err)
+	}
+	// With a list of names, only filter those.
+	if len(args) > 1 {
+		var names List
+		err = Convert(args[1], &names)
+		if err != nil {
+			return env.Fail(err)
+		}
+		res := List{}
+		for i, name := range names {
+			if name == nil {
+				return env.FailKind(ArgumentError, "glob: name "+Itoa(i)+" is nil")
+			}
+			ok, merr := path.Match(pattern, name.String())
+			if merr != nil {
+				return env.Fail(ErrorFromError(merr))
+			}
+			if ok {
+				res = append(res, name)
+			}
+		}
+		return res.SortStrings(), nil
+	}
+	fs, err := fileSystem(env)
+	if err != nil {
+		return env.Fail(err)
+	}
+	res, err := Glob(fs, pattern)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return res, nil
+}
@@ -0,0 +1,165 @@
+package tgtl
+
+import "sync"
+
+// Snapshot is an immutable copy of the variables of an environment and
+// it's settings, from which new environments can be made cheaply.
+// A Snapshot can be used by several goroutines at the same time.
+type Snapshot struct {
+	globals    Map
+	out        Writer
+	err        Writer
+	in         Reader
+	fs         FileSystem
+	execPolicy *ExecPolicy
+	clock      Clock
+	framesMax  int
+	loopsMax   int
+	unbounded  bool
+}
+
+// Snapshot returns a snapshot of the environment. The variables of all
+// frames are merged into the globals of the snapshot, and the lists,
+// maps and dicts are deeply copied, so later changes to the
+// environment do not affect the snapshot. Frozen values are shared.
+// Buffers, channels, coroutines and tasks cannot be copied, so
+// Snapshot returns an error if a variable contains one of them.
+func (env *Environment) Snapshot() (*Snapshot, *Error) {
+	globals := make(Map)
+	for _, frame := range env.Frames {
+		for name, val := range frame.All() {
+			if err := shareable(val, copies{}); err != nil {
+				return nil, ErrorFromString("Cannot snapshot variable " +
+					name + ": " + err.Error())
+			}
+			globals[name] = isolate(val)
+		}
+	}
+	return &Snapshot{
+		globals:    globals,
+		out:        env.Out,
+		err:        env.Err,
+		in:         env.In,
+		fs:         env.FS,
+		execPolicy: env.ExecPolicy,
+		clock:      env.Clock,
+		framesMax:  env.FramesMax,
+		loopsMax:   env.LoopsMax,
+		unbounded:  env.unbounded,
+	}, nil
+}
+
+// shareable returns an error if the value is or contains a value that
+// is bound to the environment or goroutine that made it.
+func shareable(val Value, seen copies) *Error {
+	val = Unfrozen(val)
+	id, ok := identify(val)
+	if _, found := seen[id]; ok && found {
+		return nil
+	}
+	if ok {
+		seen[id] = val
+	}
+	switch cv := val.(type) {
+	case *Buffer, *Channel, *Coroutine, *Task:
+		return ErrorFromString(TypeOf(cv).String() + " cannot be shared")
+	case List:
+		for _, v := range cv {
+			if err := shareable(v, seen); err != nil {
+				return err
+			}
+		}
+	case Map:
+		for _, v := range cv {
+			if err := shareable(v, seen); err != nil {
+				return err
+			}
+		}
+	case *Dict:
+		for _, k := range cv.Keys() {
+			v, _ := cv.Get(k)
+			if err := shareable(v, seen); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Reset resets the environment to the state of the snapshot.
+// The variables of the snapshot are shared copy on write,
+// so this does not copy them.
+func (snap *Snapshot) Reset(env *Environment) {
+	frames := env.Frames[0:0]
+	*env = Environment{
+		Out:        snap.out,
+		Err:        snap.err,
+		In:         snap.in,
+		FS:         snap.fs,
+		ExecPolicy: snap.execPolicy,
+		Clock:      snap.clock,
+		FramesMax:  snap.framesMax,
+		LoopsMax:   snap.loopsMax,
+		unbounded:  snap.unbounded,
+	}
+	env.Frames = append(frames, &Frame{
+		Variables: make(Map), Base: snap.globals,
+		Out: env.Out, In: env.In, Err: env.Err,
+	})
+}
+
+// Environment returns a new environment in the state of the snapshot.
+func (snap *Snapshot) Environment() *Environment {
+	env := &Environment{}
+	snap.Reset(env)
+	return env
+}
+
+// Clone returns a new environment with the variables and settings of
+// the environment. The clone does not share mutable state with the
+// environment, so it can be used in an other goroutine.
+// Like Snapshot, it returns an error if a variable contains a buffer,
+// channel, coroutine or task.
+func (env *Environment) Clone() (*Environment, *Error) {
+	snap, err := env.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	return snap.Environment(), nil
+}
+
+// EnvironmentPool hands out environments in the state of a snapshot,
+// and resets them when they are returned, for example to evaluate
+// scripts with the same prelude for many requests.
+// It is safe for use by several goroutines at the same time.
+type EnvironmentPool struct {
+	snapshot *Snapshot
+	pool     sync.Pool
+}
+
+// NewEnvironmentPool returns a pool of environments in the state
+// env is in now, or an error if env cannot be snapshot.
+func NewEnvironmentPool(env *Environment) (*EnvironmentPool, *Error) {
+	snap, err := env.Snapshot()
+	if err != nil {
+		return nil, err
+	}
+	pool := &EnvironmentPool{snapshot: snap}
+	pool.pool.New = func() interface{} {
+		return pool.snapshot.Environment()
+	}
+	return pool, nil
+}
+
+// Get returns an environment from the pool.
+func (pool *EnvironmentPool) Get() *Environment {
+	return pool.pool.Get().(*Environment)
+}
+
+// Put closes and resets the environment and returns it to the pool.
+// The environment must not be used anymore afterwards.
+func (pool *EnvironmentPool) Put(env *Environment) {
+	env.Close()
+	pool.snapshot.Reset(env)
+	pool.pool.Put(env)
+}
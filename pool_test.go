@@ -0,0 +1,101 @@
+package tgtl
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newPreludeEnvironment(t *testing.T) *Environment {
+	env, _ := newTestEnvironment()
+	parsed, perr := Parse("to double n { imul $n 2 }\nlet items [list 1 2 3]\nlet config [map name prelude]\nlet cfg [freeze [list 1 2]]\n")
+	if perr != nil {
+		t.Fatalf("error: unexpected parse error: %v", perr)
+	}
+	if _, eff := parsed.Eval(env); eff != nil {
+		t.Fatalf("error: unexpected effect: %v", eff)
+	}
+	return env
+}
+
+func runInEnvironment(t *testing.T, env *Environment, script string) string {
+	out := &strings.Builder{}
+	env.Out = out
+	env.Bottom().Out = out
+	parsed, perr := Parse(script)
+	if perr != nil {
+		t.Fatalf("error: unexpected parse error: %v", perr)
+	}
+	if _, eff := parsed.Eval(env); eff != nil {
+		t.Errorf("error: unexpected effect: %v", eff)
+	}
+	return out.String()
+}
+
+func TestClone(t *testing.T) {
+	env := newPreludeEnvironment(t)
+	clone, err := env.Clone()
+	if err != nil {
+		t.Fatalf("error: unexpected clone error: %v", err)
+	}
+	res := runInEnvironment(t, clone, "lset $items 0 10\nmset $config name clone\nlet extra 1\nprint \"$1 $2 $3\" [double 21] $items [mget $config name]\n")
+	if res != "42 [list 10 2 3] clone" {
+		t.Errorf("error: clone output not as expected: %q", res)
+	}
+	res = runInEnvironment(t, clone, "print \"$1 \" [frozen $cfg]\nprint \"$1\" [try { lset $cfg 0 9 } catch { val failed }]\n")
+	if res != "true [list failed]" {
+		t.Errorf("error: frozen value not frozen in clone: %q", res)
+	}
+	res = runInEnvironment(t, env, "print \"$1 $2 $3\" $items [mget $config name] $extra\n")
+	if res != "[list 1 2 3] prelude !nil" {
+		t.Errorf("error: clone modified the original: %q", res)
+	}
+}
+
+func TestEnvironmentPool(t *testing.T) {
+	pool, err := NewEnvironmentPool(newPreludeEnvironment(t))
+	if err != nil {
+		t.Fatalf("error: unexpected pool error: %v", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				env := pool.Get()
+				res := runInEnvironment(t, env, "print \"$1 \" $leftover\nlet leftover 1\nlset $items 0 [double [lget $items 2]]\nprint \"$1\" $items\n")
+				if res != "!nil [list 6 2 3]" {
+					t.Errorf("error: pooled environment not reset: %q", res)
+				}
+				pool.Put(env)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSnapshotUnshareable(t *testing.T) {
+	for _, script := range []string{
+		"let b [buffer]\n",
+		"let c [list 1 [map ch [channel]]]\n",
+		"let g [freeze [list [generator { yield 1 }]]]\n",
+		"let t [spawn { nop }]\n",
+	} {
+		env, _ := newTestEnvironment()
+		parsed, perr := Parse(script)
+		if perr != nil {
+			t.Fatalf("error: unexpected parse error: %v", perr)
+		}
+		if _, eff := parsed.Eval(env); eff != nil {
+			t.Fatalf("error: unexpected effect: %v", eff)
+		}
+		if _, err := env.Clone(); err == nil {
+			t.Errorf("error: clone should fail for %q", script)
+		}
+		if _, err := NewEnvironmentPool(env); err == nil {
+			t.Errorf("error: pool should fail for %q", script)
+		}
+		env.Close()
+	}
+}
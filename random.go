@@ -0,0 +1,96 @@
+package tgtl
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"math/rand"
+)
+
+// Rand returns the random number generator of the environment.
+// If none is set, one is created that is seeded with the current time
+// of the clock of the environment.
+func (env *Environment) Rand() *rand.Rand {
+	if env.Random == nil {
+		env.Random = rand.New(rand.NewSource(env.Now().UnixNano()))
+	}
+	return env.Random
+}
+
+// Seed replaces the random number generator of the environment with
+// one that is seeded with the given seed.
+func (env *Environment) Seed(seed int64) {
+	env.Random = rand.New(rand.NewSource(seed))
+}
+
+// UUID returns a random, version 4 UUID generated with the
+// random number generator of the environment.
+func (env *Environment) UUID() string {
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[0:8], env.Rand().Uint64())
+	binary.BigEndian.PutUint64(buf[8:16], env.Rand().Uint64())
+	buf[6] = (buf[6] & 0x0f) | 0x40
+	buf[8] = (buf[8] & 0x3f) | 0x80
+	s := hex.EncodeToString(buf)
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
+
+func randInt(env *Environment, args ...Value) (Value, Effect) {
+	var low, high Int
+	err := Args(args, &high)
+	if err != nil {
+		return env.Fail(err)
+	}
+	// With two arguments, the range is inclusive.
+	if len(args) > 1 {
+		low = high
+		err = Convert(args[1], &high)
+		if err != nil {
+			return env.Fail(err)
+		}
+		high++
+	}
+	if high <= low {
+		return env.FailString("rand_int: empty range")
+	}
+	return low + Int(env.Rand().Int63n(int64(high-low))), nil
+}
+
+func randChoice(env *Environment, args ...Value) (Value, Effect) {
+	var list List
+	err := Args(args, &list)
+	if err != nil {
+		return env.Fail(err)
+	}
+	if len(list) < 1 {
+		return env.FailString("rand_choice: empty list")
+	}
+	return list[env.Rand().Intn(len(list))], nil
+}
+
+func randShuffle(env *Environment, args ...Value) (Value, Effect) {
+	var list List
+	err := Args(args, &list)
+	if err != nil {
+		return env.Fail(err)
+	}
+	res := make(List, len(list))
+	copy(res, list)
+	env.Rand().Shuffle(len(res), func(i, j int) {
+		res[i], res[j] = res[j], res[i]
+	})
+	return res, nil
+}
+
+func randSeed(env *Environment, args ...Value) (Value, Effect) {
+	var seed Int
+	err := Args(args, &seed)
+	if err != nil {
+		return env.Fail(err)
+	}
+	env.Seed(int64(seed))
+	return seed, nil
+}
+
+func uuid(env *Environment, args ...Value) (Value, Effect) {
+	return String(env.UUID()), nil
+}
@@ -0,0 +1,57 @@
+package tgtl
+
+import (
+	"math/rand"
+	"regexp"
+	"testing"
+)
+
+func runRandomScript(t *testing.T, env *Environment, script string) string {
+	parsed, perr := Parse(script)
+	if perr != nil {
+		t.Fatalf("error: unexpected parse error: %v", perr)
+	}
+	out := env.Out.(interface{ String() string })
+	_, eff := parsed.Eval(env)
+	if eff != nil {
+		t.Fatalf("error: unexpected effect: %v", eff)
+	}
+	return out.String()
+}
+
+func TestRandomReproducible(t *testing.T) {
+	script := `rand_seed 42
+print "$1 $2 $3 $4 " [rand_int 100] [rand_int 5 7] [rand_choice [list a b c]] [rand_shuffle [list 1 2 3 4 5]]
+print "$1" [uuid]
+`
+	env1, _ := newTestEnvironment()
+	env2, _ := newTestEnvironment()
+	res1 := runRandomScript(t, env1, script)
+	res2 := runRandomScript(t, env2, script)
+	if res1 != res2 {
+		t.Errorf("error: same seed gave different results: %q <-> %q", res1, res2)
+	}
+	uuid := regexp.MustCompile(`[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+	if !uuid.MatchString(res1) {
+		t.Errorf("error: not a version 4 UUID: %q", res1)
+	}
+
+	// The generator of one environment does not affect another.
+	env3, _ := newTestEnvironment()
+	env3.Random = rand.New(rand.NewSource(42))
+	env1.Seed(42)
+	env1.Rand().Int63()
+	if got, expect := env3.Rand().Int63(), rand.New(rand.NewSource(42)).Int63(); got != expect {
+		t.Errorf("error: environments share random state: %d <-> %d", got, expect)
+	}
+}
+
+func TestRandomBuiltins(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`rand_seed 1; print "$1" [rand_int 1]`, "0", false},
+		sTestCase{`rand_seed 1; print "$1" [rand_int 3 3]`, "3", false},
+		sTestCase{`print "$1" [lsort [rand_shuffle [list c a b]]]`, "[list a b c]", false},
+		sTestCase{`rand_int 0`, "", true},
+		sTestCase{`rand_choice [list]`, "", true},
+	})
+}
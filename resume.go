@@ -0,0 +1,569 @@
+package tgtl
+
+import (
+	"encoding/json"
+)
+
+// ResumableVersion is the version of the format of encoded
+// resumable scripts.
+const ResumableVersion = 1
+
+// Resumable is a script that can be suspended at a checkpoint, encoded
+// to bytes, and later decoded and resumed, for example in another
+// process. The continuation of a resumable script is explicit: it is
+// the index of the next top level statement of the script, and a stack
+// of the blocks, branches, loops and procedures the checkpoint is nested
+// in, each with the statement or iteration to continue with. The frames
+// and variables of the environment are saved with it.
+//
+// Checkpoints can be used in blocks of if and switch, in the loops
+// while, repeat, for, and leach and meach over lists and maps, and in
+// defined procedures that are called by a statement. Other builtins
+// that evaluate blocks, such as try, cannot be continued, and neither
+// can the command that needs the value of an evaluation such as
+// [checkpoint], so a checkpoint in them fails.
+//
+// Builtins are not encoded, the environment the script is decoded in
+// must provide them. Variables that are set to values that cannot be
+// serialized, such as wrappers or channels, make encoding fail.
+type Resumable struct {
+	// Script is the script that is run.
+	Script Block
+	// Next is the index of the next statement to run.
+	Next int
+	// Label is the label of the checkpoint the script is suspended at.
+	Label Value
+	// Await is the variable that is set to the value the script is
+	// resumed with, if any.
+	Await     Word
+	suspended bool
+	env       *Environment
+	depth     int
+	// stack is the continuation of the checkpoint in the statement,
+	// innermost first, and frames are the frames it was suspended in.
+	stack  []continuation
+	frames []*Frame
+}
+
+const (
+	blockContinuation     = "block"
+	branchContinuation    = "branch"
+	loopContinuation      = "loop"
+	procedureContinuation = "procedure"
+)
+
+// continuation describes how to continue a block, branch, loop or
+// procedure a suspended script was evaluating, once the evaluation
+// nested in it is done.
+type continuation struct {
+	kind   string
+	depth  int    // depth of the frames, relative to the script
+	labels []Word // labels of the active loops
+	block  Block  // block: the block
+	next   int    // block: the next statement of the block
+	loop   string // loop: the name of the loop
+	state  List   // loop: what the loop needs to continue
+}
+
+// loopResumers continue the loops that can be suspended by name,
+// with the state they were suspended with and the result of the
+// iteration that was suspended.
+var loopResumers = map[string]func(env *Environment, state List, val Value, eff Effect) (Value, Effect){
+	"while":  resumeWhile,
+	"repeat": resumeRepeat,
+	"for":    resumeFor,
+	"leach":  resumeLeach,
+	"meach":  resumeMeach,
+}
+
+// suspended adds the continuation to the effect if it suspends a
+// resumable script. Other effects are returned as they are.
+func (env *Environment) suspended(eff Effect, cont continuation) Effect {
+	sus, ok := eff.(Suspend)
+	if !ok || env.Resumable == nil {
+		return eff
+	}
+	cont.depth = env.Depth() - env.Resumable.depth
+	cont.labels = append([]Word(nil), env.Labels...)
+	sus.stack = append(sus.stack, cont)
+	return sus
+}
+
+// suspendedLoop adds the continuation of the loop with the name and
+// state to the effect if it suspends a resumable script.
+func (env *Environment) suspendedLoop(eff Effect, loop string, state ...Value) Effect {
+	return env.suspended(eff, continuation{kind: loopContinuation,
+		loop: loop, state: state})
+}
+
+// suspends checks that the command that evaluated the order named name
+// can be continued once the script it suspended is resumed. That is
+// the case if it suspended the script itself, if it is a defined
+// procedure, or if it continues the evaluation it nests itself, like a
+// branch or a loop. Otherwise it fails.
+func (env *Environment) suspends(name string, eva Evaler, val Value, eff Effect) (Value, Effect) {
+	sus, ok := eff.(Suspend)
+	if !ok || len(sus.stack) == 0 {
+		return val, eff
+	}
+	last := sus.stack[len(sus.stack)-1]
+	switch last.kind {
+	case procedureContinuation:
+		if _, ok := eva.(Defined); ok {
+			return val, eff
+		}
+	case branchContinuation, loopContinuation:
+		if env.Resumable != nil && last.depth == env.Depth()-env.Resumable.depth {
+			return val, eff
+		}
+	}
+	return env.FailString("checkpoint: cannot suspend in " + name)
+}
+
+// resume continues the evaluation nested in the block, branch, loop
+// or procedure with it's result.
+func (cont continuation) resume(env *Environment, val Value, eff Effect) (Value, Effect) {
+	switch cont.kind {
+	case blockContinuation:
+		if eff != nil && eff.Flow() > NormalFlow {
+			if eff.Flow() == FailFlow {
+				val, eff = env.Rescue(val, eff)
+			}
+			return val, eff
+		}
+		if cont.next >= len(cont.block.Statements) {
+			return val, eff
+		}
+		env.Define("RESULT", val, 0)
+		val, eff, _ = cont.block.evalFrom(env, false, cont.next)
+		return val, eff
+	case loopContinuation:
+		resumer, ok := loopResumers[cont.loop]
+		if !ok {
+			return env.FailString("cannot resume loop " + cont.loop)
+		}
+		return resumer(env, cont.state, val, eff)
+	case procedureContinuation:
+		return env.procedureResult(val, eff)
+	default: // branches have the result of their block
+		return val, eff
+	}
+}
+
+// NewResumable returns a resumable script that runs in the environment.
+func NewResumable(env *Environment, script Block) *Resumable {
+	return &Resumable{Script: script, env: env}
+}
+
+// ParseResumable parses the input as a resumable script
+// that runs in the environment.
+func ParseResumable(env *Environment, input string) (*Resumable, *Error) {
+	parsed, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	script, _ := parsed.(Block)
+	return NewResumable(env, script), nil
+}
+
+// Environment returns the environment the script runs in.
+func (r *Resumable) Environment() *Environment {
+	return r.env
+}
+
+// Suspended returns true if the script is suspended at a checkpoint.
+func (r *Resumable) Suspended() bool {
+	return r.suspended
+}
+
+// Done returns true if all statements of the script have been run.
+func (r *Resumable) Done() bool {
+	return !r.suspended && r.Next >= len(r.Script.Statements)
+}
+
+// Run runs the script until it is done or suspended. If it is
+// suspended, Run returns the label of the checkpoint and a Suspend
+// effect. Otherwise it returns the result of the script like Block.Eval.
+func (r *Resumable) Run() (Value, Effect) {
+	if r.suspended {
+		return nil, ErrorFromString("script is suspended, it must be resumed")
+	}
+	defer r.enter()()
+	return r.run()
+}
+
+// Resume continues a suspended script with the value, which becomes
+// the result of the checkpoint, and runs it like Run.
+func (r *Resumable) Resume(val Value) (Value, Effect) {
+	if !r.suspended {
+		return nil, ErrorFromString("script is not suspended")
+	}
+	defer r.enter()()
+	env := r.env
+	labels := env.Labels
+	r.suspended = false
+	// Restore the frames the script was suspended in.
+	env.Frames = append(env.Frames, r.frames...)
+	if r.Await != "" {
+		env.Top().Variables[r.Await.String()] = val
+	}
+	env.Define("RESULT", val, 0)
+	r.Label = nil
+	r.Await = ""
+	stack := r.stack
+	r.stack, r.frames = nil, nil
+	// Continue the evaluation the checkpoint is nested in,
+	// from the inside out, each at the depth it was at.
+	var eff Effect
+	for i, cont := range stack {
+		for env.Depth() > r.depth+cont.depth {
+			env.Pop()
+		}
+		env.Labels = cont.labels
+		val, eff = cont.resume(env, val, eff)
+		if sus, ok := eff.(Suspend); ok {
+			// Suspended again, the rest of the continuation remains.
+			sus.stack = append(sus.stack, stack[i+1:]...)
+			eff = sus
+			break
+		}
+	}
+	for env.Depth() > r.depth {
+		env.Pop()
+	}
+	env.Labels = labels
+	val, eff, stop := r.result(val, eff)
+	if stop || r.Next >= len(r.Script.Statements) {
+		return val, eff
+	}
+	return r.run()
+}
+
+// enter makes the script the running script of it's environment.
+// The returned function must be called when it stops running.
+func (r *Resumable) enter() func() {
+	env := r.env
+	outer := env.Resumable
+	env.Resumable = r
+	r.depth = env.Depth()
+	return func() {
+		env.Resumable = outer
+	}
+}
+
+// run runs the next top level statements of the script.
+func (r *Resumable) run() (Value, Effect) {
+	var res Value
+	var eff Effect
+	for r.Next < len(r.Script.Statements) {
+		statement := r.Script.Statements[r.Next]
+		r.Next++
+		var stop bool
+		if res, eff, stop = r.result(statement.Eval(r.env)); stop {
+			return res, eff
+		}
+	}
+	return res, eff
+}
+
+// result handles the result of a top level statement. It returns true
+// if the script stops, because it is suspended or because the flow is
+// not normal anymore.
+func (r *Resumable) result(res Value, eff Effect) (Value, Effect, bool) {
+	env := r.env
+	if eff == nil || eff.Flow() <= NormalFlow {
+		env.Define("RESULT", res, 0)
+		return res, eff, false
+	}
+	if suspend, ok := eff.(Suspend); ok {
+		r.suspended = true
+		r.Label = suspend.Label
+		r.Await = suspend.Name
+		r.stack = suspend.stack
+		return suspend.Label, suspend, true
+	}
+	if eff.Flow() == FailFlow {
+		res, eff = env.Rescue(res, eff)
+	}
+	// Like in a block, any other effect ends the script.
+	r.Next = len(r.Script.Statements)
+	return res, eff, true
+}
+
+// resumableFrame is an encoded frame of a resumable script.
+type resumableFrame struct {
+	Variables map[string]json.RawMessage `json:"variables"`
+	Rescuer   json.RawMessage            `json:"rescuer,omitempty"`
+	Procedure bool                       `json:"procedure,omitempty"`
+	Deferred  []json.RawMessage          `json:"deferred,omitempty"`
+}
+
+// resumableContinuation is an encoded continuation of a resumable script.
+type resumableContinuation struct {
+	Kind   string          `json:"kind"`
+	Depth  int             `json:"depth"`
+	Labels []string        `json:"labels,omitempty"`
+	Block  json.RawMessage `json:"block,omitempty"`
+	Next   int             `json:"next,omitempty"`
+	Loop   string          `json:"loop,omitempty"`
+	State  json.RawMessage `json:"state,omitempty"`
+}
+
+// resumableState is an encoded resumable script.
+type resumableState struct {
+	Version      int                     `json:"version"`
+	Script       json.RawMessage         `json:"script"`
+	Next         int                     `json:"next"`
+	Suspended    bool                    `json:"suspended"`
+	Label        json.RawMessage         `json:"label"`
+	Await        string                  `json:"await,omitempty"`
+	Frames       []resumableFrame        `json:"frames"`
+	Nested       []resumableFrame        `json:"nested,omitempty"`
+	Continuation []resumableContinuation `json:"continuation,omitempty"`
+}
+
+// Encode serializes the script, it's continuation and the variables
+// of it's environment to JSON. Procs are not encoded.
+// A script cannot be encoded while it is running.
+func (r *Resumable) Encode() ([]byte, *Error) {
+	if r.env.Resumable == r {
+		return nil, ErrorFromString("cannot encode a running script")
+	}
+	var err *Error
+	state := resumableState{
+		Version:   ResumableVersion,
+		Next:      r.Next,
+		Suspended: r.suspended,
+		Await:     r.Await.String(),
+	}
+	if state.Script, err = EncodeValue(r.Script); err != nil {
+		return nil, err
+	}
+	if state.Label, err = EncodeValue(r.Label); err != nil {
+		return nil, err
+	}
+	if state.Frames, err = encodeFrames(r.env.Frames); err != nil {
+		return nil, err
+	}
+	if state.Nested, err = encodeFrames(r.frames); err != nil {
+		return nil, err
+	}
+	for _, cont := range r.stack {
+		encoded := resumableContinuation{
+			Kind:  cont.kind,
+			Depth: cont.depth,
+			Next:  cont.next,
+			Loop:  cont.loop,
+		}
+		for _, label := range cont.labels {
+			encoded.Labels = append(encoded.Labels, label.String())
+		}
+		if cont.kind == blockContinuation {
+			if encoded.Block, err = EncodeValue(cont.block); err != nil {
+				return nil, err
+			}
+		}
+		if cont.kind == loopContinuation {
+			if encoded.State, err = EncodeValue(cont.state); err != nil {
+				return nil, err
+			}
+		}
+		state.Continuation = append(state.Continuation, encoded)
+	}
+	res, jerr := json.Marshal(state)
+	if jerr != nil {
+		return nil, ErrorFromError(jerr)
+	}
+	return res, nil
+}
+
+func encodeFrames(frames []*Frame) ([]resumableFrame, *Error) {
+	var res []resumableFrame
+	var err *Error
+	for _, frame := range frames {
+		encoded := resumableFrame{
+			Variables: map[string]json.RawMessage{},
+			Procedure: frame.Procedure,
+		}
+		for name, val := range frame.All() {
+			if _, ok := val.(Proc); ok {
+				continue
+			}
+			data, err := EncodeValue(val)
+			if err != nil {
+				return nil, ErrorFromString("cannot encode variable " +
+					name + ": " + err.Message).WithKind(err.Kind)
+			}
+			encoded.Variables[name] = data
+		}
+		if frame.Rescuer != nil {
+			if encoded.Rescuer, err = EncodeValue(frame.Rescuer); err != nil {
+				return nil, err
+			}
+		}
+		for _, block := range frame.Deferred {
+			data, err := EncodeValue(block)
+			if err != nil {
+				return nil, err
+			}
+			encoded.Deferred = append(encoded.Deferred, data)
+		}
+		res = append(res, encoded)
+	}
+	return res, nil
+}
+
+func decodeFrame(encoded resumableFrame, frame *Frame) *Error {
+	var err *Error
+	for name, data := range encoded.Variables {
+		val, err := DecodeValue(data)
+		if err != nil {
+			return err
+		}
+		frame.Variables[name] = val
+	}
+	if encoded.Rescuer != nil {
+		if frame.Rescuer, err = DecodeValue(encoded.Rescuer); err != nil {
+			return err
+		}
+	}
+	frame.Procedure = encoded.Procedure
+	for _, data := range encoded.Deferred {
+		val, err := DecodeValue(data)
+		if err != nil {
+			return err
+		}
+		block, ok := val.(Block)
+		if !ok {
+			return ErrorWithKind(TypeError, "deferred value is not a block")
+		}
+		frame.Deferred = append(frame.Deferred, block)
+	}
+	return nil
+}
+
+// DecodeResumable deserializes a script that was encoded by Encode
+// and restores it's variables in the environment, which should already
+// have the builtins the script uses registered. Frames that the
+// environment does not have yet are pushed.
+func DecodeResumable(env *Environment, data []byte) (*Resumable, *Error) {
+	state := resumableState{}
+	if jerr := json.Unmarshal(data, &state); jerr != nil {
+		return nil, ErrorFromError(jerr)
+	}
+	if state.Version != ResumableVersion {
+		return nil, ErrorFromString("unsupported resumable script version " +
+			Itoa(state.Version))
+	}
+	decoded, err := DecodeValue(state.Script)
+	if err != nil {
+		return nil, err
+	}
+	script, ok := decoded.(Block)
+	if !ok {
+		return nil, ErrorWithKind(TypeError, "resumable script is not a block")
+	}
+	r := NewResumable(env, script)
+	r.Next = state.Next
+	r.suspended = state.Suspended
+	r.Await = Word(state.Await)
+	if r.Label, err = DecodeValue(state.Label); err != nil {
+		return nil, err
+	}
+	for i, encoded := range state.Frames {
+		if i >= env.Depth() {
+			if err := env.Push(); err != nil {
+				return nil, err
+			}
+		}
+		if err := decodeFrame(encoded, env.Frames[i]); err != nil {
+			return nil, err
+		}
+	}
+	top := env.Top()
+	for _, encoded := range state.Nested {
+		frame := &Frame{Variables: make(Map), Out: env.Out, In: env.In, Err: env.Err}
+		if top != nil {
+			frame.Out, frame.In, frame.Err = top.Out, top.In, top.Err
+		}
+		if err := decodeFrame(encoded, frame); err != nil {
+			return nil, err
+		}
+		r.frames = append(r.frames, frame)
+	}
+	for _, encoded := range state.Continuation {
+		cont := continuation{
+			kind:  encoded.Kind,
+			depth: encoded.Depth,
+			next:  encoded.Next,
+			loop:  encoded.Loop,
+		}
+		for _, label := range encoded.Labels {
+			cont.labels = append(cont.labels, Word(label))
+		}
+		switch cont.kind {
+		case blockContinuation:
+			val, err := DecodeValue(encoded.Block)
+			if err != nil {
+				return nil, err
+			}
+			if cont.block, ok = val.(Block); !ok {
+				return nil, ErrorWithKind(TypeError, "continuation is not a block")
+			}
+		case loopContinuation:
+			val, err := DecodeValue(encoded.State)
+			if err != nil {
+				return nil, err
+			}
+			if cont.state, ok = val.(List); !ok {
+				return nil, ErrorWithKind(TypeError, "loop state is not a list")
+			}
+		case branchContinuation, procedureContinuation:
+		default:
+			return nil, ErrorWithKind(TypeError, "unknown continuation "+cont.kind)
+		}
+		r.stack = append(r.stack, cont)
+	}
+	return r, nil
+}
+
+// Checkpoint suspends the resumable script that runs in the
+// environment. If name is not empty, the variable with that name is
+// set to the value the script is resumed with. It fails if no
+// resumable script is running. If the evaluation the checkpoint is
+// nested in cannot be continued, the command that nests it fails.
+func (env *Environment) Checkpoint(name Word, label Value) (Value, Effect) {
+	r := env.Resumable
+	if r == nil {
+		return env.FailString("checkpoint: script is not resumable")
+	}
+	// The command of the checkpoint pushes one frame,
+	// the script continues in the frames below it.
+	top := env.Depth() - 1
+	if top < r.depth {
+		top = r.depth
+	}
+	r.frames = append([]*Frame(nil), env.Frames[r.depth:top]...)
+	return label, Suspend{Label: label, Name: name}
+}
+
+func checkpoint(env *Environment, args ...Value) (Value, Effect) {
+	var label Value
+	if len(args) > 0 {
+		label = args[0]
+	}
+	return env.Checkpoint("", label)
+}
+
+func await(env *Environment, args ...Value) (Value, Effect) {
+	var name Word
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	var label Value
+	if len(args) > 1 {
+		label = args[1]
+	}
+	return env.Checkpoint(name, label)
+}
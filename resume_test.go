@@ -0,0 +1,202 @@
+package tgtl
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSerializeBuiltins(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`let v [list 1 "two" three [map a [list true]]]; print "$1" [eq [deserialize [serialize $v]] $v]`,
+			"true", false},
+		sTestCase{`let d [dict b 1 a 2]; print "$1" [deserialize [serialize $d]]`,
+			"[dict b 1 a 2]", false},
+		sTestCase{`let b [unhex "cafe"]; print "$1" [eq [deserialize [serialize $b]] $b]`,
+			"true", false},
+		sTestCase{`let d [duration "1m"]; print "$1" [eq [deserialize [serialize $d]] $d]`,
+			"true", false},
+		sTestCase{`let e [try { fail $IndexError "gone" 7 } catch e { get e }]; let c [deserialize [serialize $e]]; print "$1 $2 $3" [ekind $c] [emessage $c] [epayload $c]`,
+			"IndexError gone 7", false},
+		sTestCase{`to double n { imul $n 2 }; let d [deserialize [serialize [get double]]]; print "$1" [d 21]`,
+			"42", false},
+		sTestCase{`let f [freeze [list 1]]; print "$1" [frozen [deserialize [serialize $f]]]`,
+			"true", false},
+		sTestCase{`serialize [channel]`, "", true},
+		sTestCase{`deserialize "[\"bogus\", 1]"`, "", true},
+		sTestCase{`checkpoint here`, "", true},
+		sTestCase{`await answer`, "", true},
+	})
+}
+
+// resumeEncoded decodes a resumable script in a fresh environment.
+func resumeEncoded(t *testing.T, data []byte) (*Resumable, *strings.Builder) {
+	env, out := newTestEnvironment()
+	r, err := DecodeResumable(env, data)
+	if err != nil {
+		t.Fatalf("error: unexpected decode error: %v", err)
+	}
+	return r, out
+}
+
+func TestResumable(t *testing.T) {
+	env, out := newTestEnvironment()
+	script := "let count 1\nto greet who { sadd \"hello \" $who }\n" +
+		"checkpoint first\nset count [iadd $count 1]\n" +
+		"await answer [map step approval]\nprint \"$1 $2\" $count [greet $answer]\n"
+	r, err := ParseResumable(env, script)
+	if err != nil {
+		t.Fatalf("error: unexpected parse error: %v", err)
+	}
+	val, eff := r.Run()
+	if _, ok := eff.(Suspend); !ok || val.String() != "first" || !r.Suspended() {
+		t.Fatalf("error: expected suspend at first: %v %v", val, eff)
+	}
+	data, err := r.Encode()
+	if err != nil {
+		t.Fatalf("error: unexpected encode error: %v", err)
+	}
+
+	r, out = resumeEncoded(t, data)
+	val, eff = r.Resume(nil)
+	if _, ok := eff.(Suspend); !ok || r.Await != "answer" {
+		t.Fatalf("error: expected suspend at await: %v %v", val, eff)
+	}
+	if step, _ := val.(Map).Get(String("step")); step.String() != "approval" {
+		t.Errorf("error: label not as expected: %v", val)
+	}
+	data, err = r.Encode()
+	if err != nil {
+		t.Fatalf("error: unexpected encode error: %v", err)
+	}
+
+	r, out = resumeEncoded(t, data)
+	_, eff = r.Resume(String("bob"))
+	if eff != nil {
+		t.Fatalf("error: unexpected effect: %v", eff)
+	}
+	if !r.Done() {
+		t.Errorf("error: script should be done")
+	}
+	if res := out.String(); res != "2 hello bob" {
+		t.Errorf("error: output not as expected: %q", res)
+	}
+	if _, eff = r.Resume(nil); eff == nil {
+		t.Errorf("error: resuming a done script should fail")
+	}
+}
+
+// runResumable runs the script and resumes it with each of the values
+// in turn, encoding and decoding it in a fresh environment every time
+// it is suspended. It returns the output of the last environment.
+func runResumable(t *testing.T, script string, values ...Value) string {
+	env, out := newTestEnvironment()
+	r, err := ParseResumable(env, script)
+	if err != nil {
+		t.Fatalf("error: unexpected parse error: %v", err)
+	}
+	_, eff := r.Run()
+	output := out.String()
+	for _, val := range values {
+		if !r.Suspended() {
+			t.Fatalf("error: expected script to be suspended: %v", eff)
+		}
+		data, err := r.Encode()
+		if err != nil {
+			t.Fatalf("error: unexpected encode error: %v", err)
+		}
+		r, out = resumeEncoded(t, data)
+		_, eff = r.Resume(val)
+		output += out.String()
+	}
+	if eff != nil || !r.Done() {
+		t.Fatalf("error: script should be done: %v", eff)
+	}
+	return output
+}
+
+func TestResumableNestedCheckpoint(t *testing.T) {
+	cases := []struct {
+		script   string
+		values   []Value
+		expected string
+	}{
+		{"to ask n { await a $n; print \"$1 \" $a; set total [iadd $total $a] }\n" +
+			"let total 0\n" +
+			"for i 1 3 { if [eq $i 2] { print \"skip \" } else { ask $i } }\n" +
+			"print \"$1\" $total\n",
+			[]Value{Int(10), Int(20)}, "10 skip 20 30"},
+		{"to step { defer { print \"done \" }; checkpoint s; print \"after \" }\n" +
+			"let i 0\n" +
+			"while { ilt $i 5 } { set i [iadd $i 1]; if [eq $i 2] { step; break } }\n" +
+			"print \"$1\" $i\n",
+			[]Value{nil}, "after done 2"},
+		{"leach [list a b c] k v { checkpoint $v; if [eq $v b] { continue }; print \"$1 \" $v }\n",
+			[]Value{nil, nil, nil}, "a c "},
+		{"repeat 2 { switch $1 0 { await x; print \"$1 \" $x } default { print \"d\" } }\n",
+			[]Value{String("w")}, "w d"},
+		{"meach [map a 1 b 2] k v { checkpoint $k; print \"$1\" $v } sorted\n",
+			[]Value{nil, nil}, "12"},
+		{"to g { checkpoint x; print \"g\" }\nto f { if true { g } }\nf\nprint \"f\"\n",
+			[]Value{nil}, "gf"},
+	}
+	for _, tc := range cases {
+		if res := runResumable(t, tc.script, tc.values...); res != tc.expected {
+			t.Errorf("error: output not as expected for %q: %q", tc.script, res)
+		}
+	}
+}
+
+func TestResumableInProcess(t *testing.T) {
+	env, out := newTestEnvironment()
+	r, err := ParseResumable(env, "for i 1 3 { await v $i; print \"$1\" $v }\n")
+	if err != nil {
+		t.Fatalf("error: unexpected parse error: %v", err)
+	}
+	_, eff := r.Run()
+	for i := 1; i <= 3; i++ {
+		if !r.Suspended() || r.Label.String() != Itoa(i) {
+			t.Fatalf("error: expected suspend at %d: %v", i, eff)
+		}
+		_, eff = r.Resume(Int(i * 2))
+	}
+	if eff != nil || !r.Done() || env.Depth() != 1 {
+		t.Errorf("error: script should be done: %v %d", eff, env.Depth())
+	}
+	if out.String() != "246" {
+		t.Errorf("error: output not as expected: %q", out.String())
+	}
+}
+
+func TestResumableCheckpointNotContinued(t *testing.T) {
+	for _, script := range []string{
+		"try { checkpoint nested } catch { print caught }\nprint reached\n",
+		"print \"$1\" [checkpoint nested]\nprint reached\n",
+	} {
+		env, out := newTestEnvironment()
+		r, err := ParseResumable(env, script)
+		if err != nil {
+			t.Fatalf("error: unexpected parse error: %v", err)
+		}
+		_, eff := r.Run()
+		if _, ok := eff.(*Error); !ok {
+			t.Errorf("error: expected an error, got %v", eff)
+		}
+		if r.Suspended() || !r.Done() || out.String() != "" {
+			t.Errorf("error: script should have ended: %q", out.String())
+		}
+	}
+}
+
+func TestResumableUnserializable(t *testing.T) {
+	env, _ := newTestEnvironment()
+	r, err := ParseResumable(env, "let c [channel]\ncheckpoint\n")
+	if err != nil {
+		t.Fatalf("error: unexpected parse error: %v", err)
+	}
+	if _, eff := r.Run(); eff == nil || eff.Flow() != SuspendFlow {
+		t.Fatalf("error: expected suspend, got %v", eff)
+	}
+	if _, err := r.Encode(); err == nil {
+		t.Errorf("error: expected encode error for channel variable")
+	}
+}
@@ -0,0 +1,439 @@
+package tgtl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// EncodeValue serializes a value to JSON in a form that DecodeValue
+// can turn back into the same value, including it's type. Every value
+// is encoded as a JSON array with the name of the type followed by
+// the contents, and nil is encoded as null. All built-in data types
+// can be encoded, as well as parsed scripts, blocks and defined
+// procedures. Procs, wrappers, objects, coroutines, channels and tasks
+// depend on the running program and cannot be encoded.
+// The cause of errors is not encoded, only their message.
+func EncodeValue(val Value) ([]byte, *Error) {
+	tree, err := encodeValue(val)
+	if err != nil {
+		return nil, err
+	}
+	res, jerr := json.Marshal(tree)
+	if jerr != nil {
+		return nil, ErrorFromError(jerr)
+	}
+	return res, nil
+}
+
+// DecodeValue deserializes a value that was serialized by EncodeValue.
+func DecodeValue(data []byte) (Value, *Error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var tree interface{}
+	if jerr := dec.Decode(&tree); jerr != nil {
+		return nil, ErrorFromError(jerr)
+	}
+	return decodeValue(tree)
+}
+
+func encodeValues(list List) ([]interface{}, *Error) {
+	res := make([]interface{}, len(list))
+	for i, v := range list {
+		ev, err := encodeValue(v)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = ev
+	}
+	return res, nil
+}
+
+func encodeMap(m Map) (map[string]interface{}, *Error) {
+	res := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		ev, err := encodeValue(v)
+		if err != nil {
+			return nil, err
+		}
+		res[k] = ev
+	}
+	return res, nil
+}
+
+func encodeCommand(kind string, cv Command) (interface{}, *Error) {
+	order, err := encodeValue(cv.Order)
+	if err != nil {
+		return nil, err
+	}
+	params, err := encodeValues(cv.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{kind, order, params}, nil
+}
+
+func encodeValue(val Value) (interface{}, *Error) {
+	switch sv := val.(type) {
+	case nil:
+		return nil, nil
+	case Int:
+		return []interface{}{"int", int(sv)}, nil
+	case Bool:
+		return []interface{}{"bool", bool(sv)}, nil
+	case String:
+		return []interface{}{"string", string(sv)}, nil
+	case Word:
+		return []interface{}{"word", string(sv)}, nil
+	case Type:
+		return []interface{}{"type", string(sv)}, nil
+	case Comment:
+		return []interface{}{"comment", string(sv)}, nil
+	case Bytes:
+		return []interface{}{"bytes", base64.StdEncoding.EncodeToString(sv)}, nil
+	case Time:
+		return []interface{}{"time", sv.Format(time.RFC3339Nano)}, nil
+	case Duration:
+		return []interface{}{"duration", int64(sv)}, nil
+	case Frozen:
+		inner, err := encodeValue(sv.Value)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{"frozen", inner}, nil
+	case List:
+		list, err := encodeValues(sv)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{"list", list}, nil
+	case Map:
+		m, err := encodeMap(sv)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{"map", m}, nil
+	case Overload:
+		m, err := encodeMap(Map(sv))
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{"overload", m}, nil
+	case *Dict:
+		pairs := List{}
+		for _, k := range sv.Keys() {
+			v, _ := sv.Get(k)
+			pairs = append(pairs, k, v)
+		}
+		list, err := encodeValues(pairs)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{"dict", list}, nil
+	case Error:
+		return encodeError(&sv)
+	case *Error:
+		return encodeError(sv)
+	case Getter:
+		key, err := encodeValue(sv.Key)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{"getter", key}, nil
+	case Command:
+		return encodeCommand("command", sv)
+	case Evaluation:
+		return encodeCommand("evaluation", sv.Command)
+	case Block:
+		statements, err := encodeValues(sv.Statements)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{"block", statements}, nil
+	case Rescue:
+		statements, err := encodeValues(sv.Statements)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{"rescue", statements}, nil
+	case Defined:
+		params, err := encodeValues(sv.Params)
+		if err != nil {
+			return nil, err
+		}
+		statements, err := encodeValues(sv.Statements)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{"defined", sv.Name, params, statements}, nil
+	default:
+		return nil, ErrorWithKind(TypeError, "Cannot serialize "+TypeOf(val).String())
+	}
+}
+
+func encodeError(ev *Error) (interface{}, *Error) {
+	payload, err := encodeValue(ev.Payload)
+	if err != nil {
+		return nil, err
+	}
+	children, err := encodeValues(ev.Children)
+	if err != nil {
+		return nil, err
+	}
+	return []interface{}{"error", map[string]interface{}{
+		"message":  ev.Message,
+		"index":    ev.Index,
+		"kind":     string(ev.Kind),
+		"payload":  payload,
+		"children": children,
+	}}, nil
+}
+
+func decodeError(msg string) *Error {
+	return ErrorWithKind(TypeError, "Cannot deserialize: "+msg)
+}
+
+func decodeString(tree interface{}) (string, *Error) {
+	str, ok := tree.(string)
+	if !ok {
+		return "", decodeError("expected a string")
+	}
+	return str, nil
+}
+
+func decodeInt(tree interface{}) (int64, *Error) {
+	num, ok := tree.(json.Number)
+	if !ok {
+		return 0, decodeError("expected a number")
+	}
+	res, err := strconv.ParseInt(string(num), 10, 64)
+	if err != nil {
+		return 0, decodeError(err.Error())
+	}
+	return res, nil
+}
+
+func decodeValues(tree interface{}) (List, *Error) {
+	elements, ok := tree.([]interface{})
+	if !ok {
+		return nil, decodeError("expected an array")
+	}
+	res := make(List, len(elements))
+	for i, e := range elements {
+		val, err := decodeValue(e)
+		if err != nil {
+			return nil, err
+		}
+		res[i] = val
+	}
+	return res, nil
+}
+
+func decodeMap(tree interface{}) (Map, *Error) {
+	object, ok := tree.(map[string]interface{})
+	if !ok {
+		return nil, decodeError("expected an object")
+	}
+	res := make(Map, len(object))
+	for k, e := range object {
+		val, err := decodeValue(e)
+		if err != nil {
+			return nil, err
+		}
+		res[k] = val
+	}
+	return res, nil
+}
+
+func decodeCommand(order, params interface{}) (Command, *Error) {
+	ov, err := decodeValue(order)
+	if err != nil {
+		return Command{}, err
+	}
+	pv, err := decodeValues(params)
+	if err != nil {
+		return Command{}, err
+	}
+	return Command{Order: ov, Parameters: pv}, nil
+}
+
+func decodeValue(tree interface{}) (Value, *Error) {
+	if tree == nil {
+		return nil, nil
+	}
+	parts, ok := tree.([]interface{})
+	if !ok || len(parts) < 2 {
+		return nil, decodeError("expected a typed value")
+	}
+	kind, err := decodeString(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	content := parts[1]
+	switch kind {
+	case "int", "duration":
+		num, err := decodeInt(content)
+		if err != nil {
+			return nil, err
+		}
+		if kind == "duration" {
+			return Duration(num), nil
+		}
+		return Int(num), nil
+	case "bool":
+		b, ok := content.(bool)
+		if !ok {
+			return nil, decodeError("expected a boolean")
+		}
+		return Bool(b), nil
+	case "string", "word", "type", "comment", "bytes", "time":
+		str, err := decodeString(content)
+		if err != nil {
+			return nil, err
+		}
+		switch kind {
+		case "word":
+			return Word(str), nil
+		case "type":
+			return Type(str), nil
+		case "comment":
+			return Comment(str), nil
+		case "bytes":
+			buf, berr := base64.StdEncoding.DecodeString(str)
+			if berr != nil {
+				return nil, decodeError(berr.Error())
+			}
+			return Bytes(buf), nil
+		case "time":
+			tv, terr := time.Parse(time.RFC3339Nano, str)
+			if terr != nil {
+				return nil, decodeError(terr.Error())
+			}
+			return Time{tv}, nil
+		}
+		return String(str), nil
+	case "frozen":
+		inner, err := decodeValue(content)
+		if err != nil {
+			return nil, err
+		}
+		return Freeze(inner), nil
+	case "list":
+		return decodeValues(content)
+	case "map":
+		return decodeMap(content)
+	case "overload":
+		m, err := decodeMap(content)
+		return Overload(m), err
+	case "dict":
+		pairs, err := decodeValues(content)
+		if err != nil {
+			return nil, err
+		}
+		return NewDict(pairs...)
+	case "error":
+		return decodeErrorValue(content)
+	case "getter":
+		key, err := decodeValue(content)
+		if err != nil {
+			return nil, err
+		}
+		return Getter{key}, nil
+	case "command", "evaluation":
+		if len(parts) < 3 {
+			return nil, decodeError("expected order and parameters")
+		}
+		cv, err := decodeCommand(content, parts[2])
+		if err != nil {
+			return nil, err
+		}
+		if kind == "evaluation" {
+			return Evaluation{cv}, nil
+		}
+		return cv, nil
+	case "block", "rescue":
+		statements, err := decodeValues(content)
+		if err != nil {
+			return nil, err
+		}
+		if kind == "rescue" {
+			return Rescue{Block{Statements: statements}}, nil
+		}
+		return Block{Statements: statements}, nil
+	case "defined":
+		if len(parts) < 4 {
+			return nil, decodeError("expected name, parameters and block")
+		}
+		name, err := decodeString(content)
+		if err != nil {
+			return nil, err
+		}
+		params, err := decodeValues(parts[2])
+		if err != nil {
+			return nil, err
+		}
+		statements, err := decodeValues(parts[3])
+		if err != nil {
+			return nil, err
+		}
+		return Defined{name, params, Block{Statements: statements}}, nil
+	default:
+		return nil, decodeError("unknown type " + kind)
+	}
+}
+
+func decodeErrorValue(tree interface{}) (Value, *Error) {
+	fields, ok := tree.(map[string]interface{})
+	if !ok {
+		return nil, decodeError("expected an object")
+	}
+	res := &Error{}
+	var err *Error
+	if res.Message, err = decodeString(fields["message"]); err != nil {
+		return nil, err
+	}
+	index, err := decodeInt(fields["index"])
+	if err != nil {
+		return nil, err
+	}
+	res.Index = int(index)
+	kind, err := decodeString(fields["kind"])
+	if err != nil {
+		return nil, err
+	}
+	res.Kind = Type(kind)
+	if res.Payload, err = decodeValue(fields["payload"]); err != nil {
+		return nil, err
+	}
+	if res.Children, err = decodeValues(fields["children"]); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func serialize(env *Environment, args ...Value) (Value, Effect) {
+	if len(args) < 1 {
+		return env.FailKind(ArgumentError, "serialize needs 1 argument")
+	}
+	// Not converted with Args, which turns defined procedures into blocks.
+	res, err := EncodeValue(args[0])
+	if err != nil {
+		return env.Fail(err)
+	}
+	return String(res), nil
+}
+
+func deserialize(env *Environment, args ...Value) (Value, Effect) {
+	var data []byte
+	err := Args(args, &data)
+	if err != nil {
+		return env.Fail(err)
+	}
+	res, err := DecodeValue(data)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return res, nil
+}
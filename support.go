@@ -1,8 +1,16 @@
 package tgtl
 
-type Comparer func(v1, v2 Value) int
+// CompareFunc is a function that compares two values.
+// It should return a negative number if v1 sorts before v2,
+// 0 if they are equal, and a positive number if v1 sorts after v2.
+type CompareFunc func(v1, v2 Value) int
 
-func (data List) Sort(compare Comparer) List {
+// Comparer is the former name of CompareFunc.
+//
+// Deprecated: use CompareFunc.
+type Comparer = CompareFunc
+
+func (data List) Sort(compare CompareFunc) List {
 	if len(data) < 2 {
 		return data
 	}
@@ -66,13 +74,13 @@ func Itoa(i int) string {
 func Args(froms []Value, tos ...interface{}) *Error {
 	for i, to := range tos {
 		if i >= len(froms) {
-			return ErrorFromString("Too few arguments: " +
-				Itoa(len(froms)) + " in stead of " + Itoa(len(tos)))
+			return ErrorWithKind(ArgumentError, "Too few arguments: "+
+				Itoa(len(froms))+" in stead of "+Itoa(len(tos)))
 		}
 		from := froms[i]
 		err := Convert(from, to)
 		if err != nil {
-			return err
+			return err.WithKind(TypeError)
 		}
 	}
 	return nil
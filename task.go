@@ -0,0 +1,482 @@
+package tgtl
+
+import (
+	"math/rand"
+	"reflect"
+	"sync"
+)
+
+// Shared is the synchronized layer of variables that is shared
+// between an environment and all tasks it spawns. Values are frozen
+// when they are stored, so they can be read safely by all tasks.
+type Shared struct {
+	sync.RWMutex
+	Variables Map
+	// out synchronizes the writers used by the tasks.
+	out sync.Mutex
+	// coroutines are the coroutines that are not done yet.
+	coroutines map[*Coroutine]struct{}
+}
+
+// Get returns the shared variable with the name, if it exists.
+func (sh *Shared) Get(name string) (Value, bool) {
+	sh.RLock()
+	defer sh.RUnlock()
+	val, ok := sh.Variables[name]
+	return val, ok
+}
+
+// Set sets the shared variable with the name to a frozen copy of val.
+func (sh *Shared) Set(name string, val Value) Value {
+	frozen := Freeze(val)
+	sh.Lock()
+	defer sh.Unlock()
+	if sh.Variables == nil {
+		sh.Variables = make(Map)
+	}
+	sh.Variables[name] = frozen
+	return frozen
+}
+
+// syncWriter is a Writer that is safe to use from several tasks.
+type syncWriter struct {
+	mutex  *sync.Mutex
+	writer Writer
+}
+
+func (sw syncWriter) Write(p []byte) (int, error) {
+	sw.mutex.Lock()
+	defer sw.mutex.Unlock()
+	return sw.writer.Write(p)
+}
+
+func (sh *Shared) syncWriter(w Writer) Writer {
+	if w == nil {
+		return nil
+	}
+	if _, ok := w.(syncWriter); ok {
+		return w
+	}
+	return syncWriter{&sh.out, w}
+}
+
+// share creates the shared layer of the environment if needed, and
+// makes the writers of the environment safe to use from several tasks.
+func (env *Environment) share() *Shared {
+	if env.Shared == nil {
+		env.Shared = &Shared{}
+	}
+	sh := env.Shared
+	env.Out, env.Err = sh.syncWriter(env.Out), sh.syncWriter(env.Err)
+	for _, frame := range env.Frames {
+		frame.Out, frame.Err = sh.syncWriter(frame.Out), sh.syncWriter(frame.Err)
+	}
+	return sh
+}
+
+// Child returns a new environment for a task. It has one frame with
+// deep copies of all variables that are visible in the environment,
+// except for frozen values, which are shared as they are. It shares
+// the Shared layer, the writers, the file system, the context and the
+// policies of the environment. It has no reader, and it's own random
+// number generator, seeded from the one of the environment. The frames
+// of the environment count towards the maximum amount of frames of
+// the child, so recursion through tasks is limited as well.
+func (env *Environment) Child() *Environment {
+	sh := env.share()
+	vars := make(Map)
+	for _, frame := range env.Frames {
+		for name, val := range frame.All() {
+			vars[name] = isolate(val)
+		}
+	}
+	child := &Environment{
+		Out:        env.Writer(),
+		Err:        env.ErrWriter(),
+		FS:         env.FS,
+		Context:    env.Context,
+		ExecPolicy: env.ExecPolicy,
+		FramesMax:  env.FramesMax,
+		LoopsMax:   env.LoopsMax,
+		Clock:      env.Clock,
+		Shared:     sh,
+		Random:     rand.New(rand.NewSource(env.Rand().Int63())),
+		unbounded:  env.unbounded,
+		outer:      env.outer + env.Depth(),
+	}
+	child.Frames = []*Frame{&Frame{Variables: vars, Out: child.Out, Err: child.Err}}
+	return child
+}
+
+// panicError converts the value of a recovered panic to an error, so
+// a panic in the goroutine of a task or coroutine fails it in stead of
+// ending the program.
+func panicError(rec interface{}) *Error {
+	switch pv := rec.(type) {
+	case *Error:
+		return pv
+	case error:
+		return ErrorFromString("panic: " + pv.Error())
+	case string:
+		return ErrorFromString("panic: " + pv)
+	default:
+		return ErrorFromString("panic in goroutine")
+	}
+}
+
+// Task is a block that is running in it's own goroutine,
+// started with spawn.
+type Task struct {
+	Block
+	done chan struct{}
+	val  Value
+	eff  Effect
+}
+
+// Spawn evaluates the block with the arguments in a new goroutine,
+// in a child environment.
+func (env *Environment) Spawn(block Block, args ...Value) *Task {
+	child := env.Child()
+	for i, arg := range args {
+		args[i] = isolate(arg)
+	}
+	task := &Task{Block: block, done: make(chan struct{})}
+	go func() {
+		defer close(task.done)
+		defer func() {
+			if rec := recover(); rec != nil {
+				task.val, task.eff = nil, panicError(rec)
+			}
+		}()
+		if err := child.Push(); err != nil {
+			task.eff = err
+			return
+		}
+		task.val, task.eff = block.Eval(child, args...)
+		// Only failures and exits are results of the task.
+		if task.eff != nil && task.eff.Flow() != FailFlow && task.eff.Flow() != ExitFlow {
+			task.val, task.eff = task.eff.Unwrap(), nil
+		}
+	}()
+	return task
+}
+
+// Wait waits until the task is done, and returns it's result.
+// It returns an error if the context of the environment is canceled
+// while waiting.
+func (env *Environment) Wait(task *Task) (Value, Effect) {
+	if env.Context != nil {
+		select {
+		case <-task.done:
+		case <-env.Context.Done():
+			return env.Fail(env.Canceled())
+		}
+	}
+	<-task.done
+	return task.val, task.eff
+}
+
+func (task *Task) String() string {
+	return "[task " + task.Block.String() + "]"
+}
+
+func (task *Task) Eval(env *Environment, args ...Value) (Value, Effect) {
+	return task, nil
+}
+
+func (*Task) Type() Type { return Type("Task") }
+
+func (from *Task) Convert(to interface{}) *Error {
+	switch toPtr := to.(type) {
+	case **Task:
+		(*toPtr) = from
+	case *Value:
+		(*toPtr) = from
+	default:
+		return ErrorFromString("Cannot convert task value")
+	}
+	return nil
+}
+
+// Channel is a Value that sends values between tasks.
+// Values are frozen when they are sent.
+type Channel struct {
+	ch chan Value
+}
+
+// NewChannel returns a channel with the given buffer size.
+func NewChannel(size int) *Channel {
+	return &Channel{ch: make(chan Value, size)}
+}
+
+func (cv *Channel) String() string {
+	return "[channel]"
+}
+
+func (cv *Channel) Eval(env *Environment, args ...Value) (Value, Effect) {
+	return cv, nil
+}
+
+func (*Channel) Type() Type { return Type("Channel") }
+
+func (from *Channel) Convert(to interface{}) *Error {
+	switch toPtr := to.(type) {
+	case **Channel:
+		(*toPtr) = from
+	case *Value:
+		(*toPtr) = from
+	default:
+		return ErrorFromString("Cannot convert channel value")
+	}
+	return nil
+}
+
+// Send sends the frozen value on the channel. It returns an error if the
+// channel is closed, or if the context of the environment is canceled.
+func (env *Environment) Send(cv *Channel, val Value) (err *Error) {
+	defer func() {
+		if recover() != nil {
+			err = ErrorFromString("send on closed channel")
+		}
+	}()
+	val = Freeze(val)
+	if env.Context != nil {
+		select {
+		case cv.ch <- val:
+			return nil
+		case <-env.Context.Done():
+			return env.Canceled()
+		}
+	}
+	cv.ch <- val
+	return nil
+}
+
+// Receive receives a value from the channel. It returns false if the
+// channel is closed and empty, and an error if the context of the
+// environment is canceled.
+func (env *Environment) Receive(cv *Channel) (Value, bool, *Error) {
+	if env.Context != nil {
+		select {
+		case val, ok := <-cv.ch:
+			return val, ok, nil
+		case <-env.Context.Done():
+			return nil, false, env.Canceled()
+		}
+	}
+	val, ok := <-cv.ch
+	return val, ok, nil
+}
+
+func spawn(env *Environment, args ...Value) (Value, Effect) {
+	var block Block
+	err := Args(args, &block)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return env.Spawn(block, args[1:]...), nil
+}
+
+func wait(env *Environment, args ...Value) (Value, Effect) {
+	var task *Task
+	err := Args(args, &task)
+	if err != nil {
+		return env.Fail(err)
+	}
+	val, eff := env.Wait(task)
+	if terr, ok := eff.(*Error); ok {
+		return env.Fail(terr)
+	}
+	return val, eff
+}
+
+// join waits for all tasks, given as arguments or as a list,
+// and returns their results as a list. If one or more of the tasks
+// failed, the first failure is returned after all tasks are done.
+func join(env *Environment, args ...Value) (Value, Effect) {
+	tasks := List(args)
+	if len(args) == 1 {
+		if list, ok := args[0].(List); ok {
+			tasks = list
+		}
+	}
+	res := List{}
+	var first Effect
+	for _, arg := range tasks {
+		var task *Task
+		err := Convert(arg, &task)
+		if err != nil {
+			return env.Fail(err)
+		}
+		val, eff := env.Wait(task)
+		if eff != nil && first == nil {
+			first = eff
+		}
+		res = append(res, val)
+	}
+	if terr, ok := first.(*Error); ok {
+		return env.Fail(terr)
+	} else if first != nil {
+		return nil, first
+	}
+	return res, nil
+}
+
+func channel(env *Environment, args ...Value) (Value, Effect) {
+	var size int
+	if len(args) > 0 {
+		err := Args(args, &size)
+		if err != nil {
+			return env.Fail(err)
+		}
+	}
+	if size < 0 {
+		return env.FailKind(ArgumentError, "channel: negative size")
+	}
+	return NewChannel(size), nil
+}
+
+func send(env *Environment, args ...Value) (Value, Effect) {
+	var cv *Channel
+	var val Value
+	err := Args(args, &cv, &val)
+	if err != nil {
+		return env.Fail(err)
+	}
+	err = env.Send(cv, val)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return val, nil
+}
+
+// recv returns the next value of the channel, or nil if it is closed.
+func recv(env *Environment, args ...Value) (Value, Effect) {
+	var cv *Channel
+	err := Args(args, &cv)
+	if err != nil {
+		return env.Fail(err)
+	}
+	val, _, err := env.Receive(cv)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return val, nil
+}
+
+func close_(env *Environment, args ...Value) (Value, Effect) {
+	var cv *Channel
+	err := Args(args, &cv)
+	if err != nil {
+		return env.Fail(err)
+	}
+	defer func() {
+		recover() // closing twice is allowed
+	}()
+	close(cv.ch)
+	return nil, nil
+}
+
+// select_ waits until one of the channels can be received from, and
+// evaluates the block after it with the value in $1 and true in $2,
+// or false in $2 if the channel is closed. If the last channel is the
+// word default, it's block is evaluated if no channel is ready.
+func select_(env *Environment, args ...Value) (Value, Effect) {
+	if len(args) < 2 || len(args)%2 != 0 {
+		return env.FailKind(ArgumentError, "select needs pairs of channels and blocks")
+	}
+	cases := []reflect.SelectCase{}
+	blocks := []Block{}
+	for i := 0; i < len(args); i += 2 {
+		var block Block
+		err := Convert(args[i+1], &block)
+		if err != nil {
+			return env.Fail(err)
+		}
+		blocks = append(blocks, block)
+		if word, ok := args[i].(Word); ok && word == "default" {
+			cases = append(cases, reflect.SelectCase{Dir: reflect.SelectDefault})
+			continue
+		}
+		var cv *Channel
+		err = Convert(args[i], &cv)
+		if err != nil {
+			return env.Fail(err)
+		}
+		cases = append(cases, reflect.SelectCase{
+			Dir: reflect.SelectRecv, Chan: reflect.ValueOf(cv.ch),
+		})
+	}
+	if env.Context != nil {
+		cases = append(cases, reflect.SelectCase{
+			Dir: reflect.SelectRecv, Chan: reflect.ValueOf(env.Context.Done()),
+		})
+	}
+	chosen, recvd, ok := reflect.Select(cases)
+	if chosen >= len(blocks) {
+		return env.Fail(env.Canceled())
+	}
+	var val Value
+	if ok && !recvd.IsNil() {
+		val = recvd.Interface().(Value)
+	}
+	return blocks[chosen].Eval(env, val, Bool(ok))
+}
+
+// leachChannel calls the block for each value received from the
+// channel until it is closed.
+func leachChannel(env *Environment, cv *Channel, key, name Word, block Block, label Word, args ...Value) (Value, Effect) {
+	defer env.Label(label)()
+	for i := 0; ; i++ {
+		val, ok, err := env.Receive(cv)
+		if err != nil {
+			return env.Fail(err)
+		} else if !ok {
+			return cv, nil
+		}
+		env.Define(key.String(), Int(i), 0)
+		env.Define(name.String(), val, 0)
+		bval, beff := block.Eval(env, args...)
+		bval, beff, stopped := env.LoopEffect(label, bval, beff)
+		if beff != nil {
+			return bval, beff
+		} else if stopped {
+			return cv, nil
+		}
+	}
+}
+
+func global(env *Environment, args ...Value) (Value, Effect) {
+	var name string
+	err := Args(args, &name)
+	if err != nil {
+		return env.Fail(err)
+	}
+	sh := env.Shared
+	if len(args) > 1 {
+		if sh == nil {
+			sh = env.share()
+		}
+		return sh.Set(name, args[1]), nil
+	}
+	if sh == nil {
+		return nil, nil
+	}
+	val, _ := sh.Get(name)
+	return val, nil
+}
+
+// RegisterTaskBuiltins registers the builtins that run tasks and
+// communicate over channels. Tasks and channels can wait forever,
+// unless the Context of the environment is set, so these builtins
+// are not registered by RegisterBuiltins.
+func (env *Environment) RegisterTaskBuiltins() {
+	env.Register("spawn", spawn, "evaluates block $1 with the other arguments in a new task, with copies of the variables, and returns the task")
+	env.Register("wait", wait, "waits for task $1 and returns it's result, or fails if the task failed")
+	env.Register("join", join, "waits for all tasks given as arguments or as a list and returns a list of their results")
+	env.Register("channel", channel, "returns a new channel with optional buffer size $1")
+	env.Register("send", send, "sends a frozen copy of value $2 on channel $1")
+	env.Register("recv", recv, "receives a value from channel $1, or nil if it is closed")
+	env.Register("close", close_, "closes channel $1")
+	env.Register("select", select_, "waits for the first of the channels and evaluates the block after it with the value, or the block after default if none are ready")
+}
@@ -0,0 +1,78 @@
+package tgtl
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTasks(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`let t [spawn { iadd $1 $2 } 1 2]; print "$1" [wait $t]`, "3", false},
+		sTestCase{`let x 10; let t [spawn { set x 20; iadd $x 0 }]; print "$1 $2" [wait $t] $x`, "20 10", false},
+		sTestCase{`print "$1" [join [spawn { iadd 1 0 }] [spawn { iadd 2 0 }] [spawn { iadd 3 0 }]]`, "[list 1 2 3]", false},
+		sTestCase{`let ts [list]; for i 1 3 { set ts [ladd $ts [spawn { imul $1 $1 } $i]] }; print "$1" [join $ts]`,
+			"[list 1 4 9]", false},
+		sTestCase{`let t [spawn { fail $IndexError "in task" }]; print "$1" [try { wait $t } catch e IndexError { emessage $e }]`,
+			"in task", false},
+		sTestCase{`global counter 1; let t [spawn { global counter [iadd [global counter] 1] }]; wait $t; print "$1 $2" [global counter] $counter`,
+			"2 2", false},
+		sTestCase{`let c [channel]; spawn { for i 1 3 { send $c $i }; close $c }; leach $c i v { print "$v " }`,
+			"1 2 3 ", false},
+		sTestCase{`let c [channel 1]; send $c [list 1 2]; print "$1" [frozen [recv $c]]`, "true", false},
+		sTestCase{`let c [channel 1]; close $c; print "$1" [recv $c]`, "!nil", false},
+		sTestCase{`let a [channel 1]; let b [channel 1]; send $b "bee"; select $a { print "a" } $b { print "got $1" }`,
+			"got bee", false},
+		sTestCase{`let a [channel]; select $a { print "a" } default { print "none" }`, "none", false},
+		sTestCase{`let a [channel]; close $a; select $a { print "$2" }`, "false", false},
+		sTestCase{`let c [channel 1]; close $c; send $c 1`, "", true},
+		sTestCase{`let t [spawn { join [spawn { fail "inner" }] }]; wait $t`, "", true},
+		sTestCase{`let t [spawn { str $nope }]; wait $t`, "", true},
+		sTestCase{`let f [freeze [list 1]]; let t [spawn { list [frozen $f] [frozen $1] } $f]; print "$1" [wait $t]`,
+			"[list true true]", false},
+		sTestCase{`for i 1 2 { try { wait [spawn { continue }] } catch { print "f" }; print "x" }`, "fxfx", false},
+	})
+}
+
+func TestTaskCanceled(t *testing.T) {
+	env, _ := newTestEnvironment()
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	env.Context = ctx
+	parsed, _ := Parse("let c [channel]\nrecv $c\n")
+	_, eff := parsed.Eval(env)
+	if err, ok := eff.(*Error); !ok || !IsKind(err, QuotaExceeded) {
+		t.Errorf("error: expected receive to be canceled: %v", eff)
+	}
+}
+
+func TestSpawnFramesMax(t *testing.T) {
+	env, _ := newTestEnvironment()
+	env.FramesMax = 2
+	if _, eff := env.Wait(env.Spawn(Block{})); eff == nil || eff.Flow() != FailFlow {
+		t.Errorf("error: expected task to fail without frames: %v", eff)
+	}
+	co := NewCoroutine(env, Block{})
+	if _, eff, done := co.Resume(nil); !done || eff == nil || eff.Flow() != FailFlow {
+		t.Errorf("error: expected coroutine to fail without frames: %v", eff)
+	}
+}
+
+func TestTaskRecursionFramesMax(t *testing.T) {
+	env, _ := newTestEnvironment()
+	parsed, _ := Parse("to f { wait [spawn { f }] }\nf\n")
+	if _, eff := parsed.Eval(env); eff == nil || eff.Flow() != FailFlow {
+		t.Errorf("error: expected recursion through tasks to fail: %v", eff)
+	}
+}
+
+func TestTaskBuiltinsNotRestricted(t *testing.T) {
+	env := &Environment{}
+	env.Push()
+	env.RegisterBuiltins()
+	for _, name := range []string{"spawn", "wait", "join", "channel", "send", "recv", "close", "select"} {
+		if env.Lookup(name) != nil {
+			t.Errorf("error: %s should not be registered by RegisterBuiltins", name)
+		}
+	}
+}
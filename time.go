@@ -0,0 +1,352 @@
+package tgtl
+
+import (
+	"context"
+	"time"
+	// Embed the time zone database so tz works on all hosts.
+	_ "time/tzdata"
+)
+
+// Clock is the interface through which the time builtins get the
+// current time and wait. Embedders and tests can set the Clock of
+// an Environment to control the time seen by scripts.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+// SystemClock is the Clock of the operating system.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// Time is a point in time.
+type Time struct {
+	time.Time
+}
+
+// Duration is an amount of time.
+type Duration time.Duration
+
+// timeLayouts are the names of the layouts that can be used with
+// parse_time and format_time in stead of a Go layout.
+var timeLayouts = map[string]string{
+	"rfc3339":  time.RFC3339Nano,
+	"rfc1123":  time.RFC1123Z,
+	"date":     "2006-01-02",
+	"datetime": "2006-01-02 15:04:05",
+	"time":     "15:04:05",
+	"kitchen":  time.Kitchen,
+}
+
+// TimeLayout returns the Go layout for a layout name,
+// or the layout itself if it is not a known name.
+func TimeLayout(layout string) string {
+	if named, ok := timeLayouts[layout]; ok {
+		return named
+	}
+	return layout
+}
+
+func (tv Time) String() string {
+	return tv.Format(time.RFC3339Nano)
+}
+
+func (tv Time) Eval(env *Environment, args ...Value) (Value, Effect) {
+	return tv, nil
+}
+
+func (Time) Type() Type { return Type("Time") }
+
+func (from Time) Convert(to interface{}) *Error {
+	switch toPtr := to.(type) {
+	case *string:
+		(*toPtr) = from.String()
+	case *String:
+		(*toPtr) = String(from.String())
+	case *time.Time:
+		(*toPtr) = from.Time
+	case *Time:
+		(*toPtr) = from
+	case *Value:
+		(*toPtr) = from
+	default:
+		return ErrorFromString("Cannot convert Time value")
+	}
+	return nil
+}
+
+func (tv Time) Equal(other Value) bool {
+	ov, ok := other.(Time)
+	return ok && tv.Time.Equal(ov.Time)
+}
+
+func (tv Time) Compare(other Value) int {
+	ov, _ := other.(Time)
+	if tv.After(ov.Time) {
+		return 1
+	} else if tv.Before(ov.Time) {
+		return -1
+	}
+	return 0
+}
+
+func (tv Time) Hash() uint64 {
+	return hashTyped(tv.Type(), tv.UTC().String())
+}
+
+func (dv Duration) String() string {
+	return time.Duration(dv).String()
+}
+
+func (dv Duration) Eval(env *Environment, args ...Value) (Value, Effect) {
+	return dv, nil
+}
+
+func (Duration) Type() Type { return Type("Duration") }
+
+// Convert converts the duration. Durations convert to integers
+// as a number of milliseconds.
+func (from Duration) Convert(to interface{}) *Error {
+	switch toPtr := to.(type) {
+	case *string:
+		(*toPtr) = from.String()
+	case *String:
+		(*toPtr) = String(from.String())
+	case *int:
+		(*toPtr) = int(time.Duration(from).Milliseconds())
+	case *Int:
+		(*toPtr) = Int(time.Duration(from).Milliseconds())
+	case *time.Duration:
+		(*toPtr) = time.Duration(from)
+	case *Duration:
+		(*toPtr) = from
+	case *Value:
+		(*toPtr) = from
+	default:
+		return ErrorFromString("Cannot convert Duration value")
+	}
+	return nil
+}
+
+func (dv Duration) Equal(other Value) bool {
+	ov, ok := other.(Duration)
+	return ok && dv == ov
+}
+
+func (dv Duration) Compare(other Value) int {
+	ov, _ := other.(Duration)
+	if dv > ov {
+		return 1
+	} else if dv < ov {
+		return -1
+	}
+	return 0
+}
+
+func (dv Duration) Hash() uint64 {
+	return hashTyped(dv.Type(), dv.String())
+}
+
+// ToDuration converts a value to a Duration. Durations are used as is,
+// strings are parsed like "1h30m" and integers are milliseconds.
+func ToDuration(val Value) (Duration, *Error) {
+	switch v := val.(type) {
+	case Duration:
+		return v, nil
+	case Int:
+		return Duration(time.Duration(v) * time.Millisecond), nil
+	case String, Word:
+		d, err := time.ParseDuration(v.String())
+		if err != nil {
+			return 0, ErrorFromError(err)
+		}
+		return Duration(d), nil
+	}
+	return 0, ErrorFromString("Cannot convert to Duration: " + TypeOf(val).String())
+}
+
+// Now returns the current time of the clock of the environment.
+func (env *Environment) Now() time.Time {
+	if env.Clock == nil {
+		return time.Now()
+	}
+	return env.Clock.Now()
+}
+
+// Sleep waits for the duration using the clock of the environment.
+// It returns a QuotaExceeded error if the context of the environment
+// is canceled before the duration has passed.
+func (env *Environment) Sleep(d time.Duration) *Error {
+	var clock Clock = SystemClock{}
+	if env.Clock != nil {
+		clock = env.Clock
+	}
+	ctx := env.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	select {
+	case <-clock.After(d):
+		return nil
+	case <-ctx.Done():
+		return env.Canceled()
+	}
+}
+
+func now(env *Environment, args ...Value) (Value, Effect) {
+	return Time{env.Now()}, nil
+}
+
+func duration(env *Environment, args ...Value) (Value, Effect) {
+	if len(args) < 1 {
+		return env.FailKind(ArgumentError, "duration: expected 1 argument")
+	}
+	d, err := ToDuration(args[0])
+	if err != nil {
+		return env.Fail(err)
+	}
+	return d, nil
+}
+
+func parseTime(env *Environment, args ...Value) (Value, Effect) {
+	var text string
+	layout := "rfc3339"
+	err := Args(args, &text)
+	if err != nil {
+		return env.Fail(err)
+	}
+	if len(args) > 1 {
+		err = Convert(args[1], &layout)
+		if err != nil {
+			return env.Fail(err)
+		}
+	}
+	loc := time.UTC
+	if len(args) > 2 {
+		var zone string
+		err = Convert(args[2], &zone)
+		if err != nil {
+			return env.Fail(err)
+		}
+		var lerr error
+		loc, lerr = time.LoadLocation(zone)
+		if lerr != nil {
+			return env.Fail(ErrorFromError(lerr))
+		}
+	}
+	parsed, perr := time.ParseInLocation(TimeLayout(layout), text, loc)
+	if perr != nil {
+		return env.Fail(ErrorFromError(perr))
+	}
+	return Time{parsed}, nil
+}
+
+func formatTime(env *Environment, args ...Value) (Value, Effect) {
+	var tv Time
+	layout := "rfc3339"
+	err := Args(args, &tv)
+	if err != nil {
+		return env.Fail(err)
+	}
+	if len(args) > 1 {
+		err = Convert(args[1], &layout)
+		if err != nil {
+			return env.Fail(err)
+		}
+	}
+	return String(tv.Format(TimeLayout(layout))), nil
+}
+
+func tadd(env *Environment, args ...Value) (Value, Effect) {
+	if len(args) < 2 {
+		return env.FailKind(ArgumentError, "tadd: expected 2 arguments")
+	}
+	d, err := ToDuration(args[1])
+	if err != nil {
+		return env.Fail(err)
+	}
+	switch v := args[0].(type) {
+	case Time:
+		return Time{v.Add(time.Duration(d))}, nil
+	case Duration:
+		return v + d, nil
+	}
+	return env.FailString("tadd: cannot add to ${1}", args[0])
+}
+
+func tsub(env *Environment, args ...Value) (Value, Effect) {
+	if len(args) < 2 {
+		return env.FailKind(ArgumentError, "tsub: expected 2 arguments")
+	}
+	d, err := ToDuration(args[1])
+	if err != nil {
+		return env.Fail(err)
+	}
+	switch v := args[0].(type) {
+	case Time:
+		return Time{v.Add(-time.Duration(d))}, nil
+	case Duration:
+		return v - d, nil
+	}
+	return env.FailString("tsub: cannot subtract from ${1}", args[0])
+}
+
+func tdiff(env *Environment, args ...Value) (Value, Effect) {
+	var t1, t2 Time
+	err := Args(args, &t1, &t2)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return Duration(t1.Sub(t2.Time)), nil
+}
+
+func tz(env *Environment, args ...Value) (Value, Effect) {
+	var tv Time
+	var zone string
+	err := Args(args, &tv, &zone)
+	if err != nil {
+		return env.Fail(err)
+	}
+	loc, lerr := time.LoadLocation(zone)
+	if lerr != nil {
+		return env.Fail(ErrorFromError(lerr))
+	}
+	return Time{tv.In(loc)}, nil
+}
+
+func tunix(env *Environment, args ...Value) (Value, Effect) {
+	if len(args) < 1 {
+		return env.FailKind(ArgumentError, "tunix: expected 1 argument")
+	}
+	if tv, ok := args[0].(Time); ok {
+		return Int(tv.Unix()), nil
+	}
+	var secs Int
+	err := Convert(args[0], &secs)
+	if err != nil {
+		return env.Fail(err)
+	}
+	return Time{time.Unix(int64(secs), 0).UTC()}, nil
+}
+
+func sleep(env *Environment, args ...Value) (Value, Effect) {
+	if len(args) < 1 {
+		return env.FailKind(ArgumentError, "sleep: expected 1 argument")
+	}
+	d, err := ToDuration(args[0])
+	if err != nil {
+		return env.Fail(err)
+	}
+	err = env.Sleep(time.Duration(d))
+	if err != nil {
+		return env.Fail(err)
+	}
+	return d, nil
+}
@@ -0,0 +1,88 @@
+package tgtl
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock that only advances when it is slept on.
+type fakeClock struct {
+	now time.Time
+}
+
+func (fc *fakeClock) Now() time.Time {
+	return fc.now
+}
+
+func (fc *fakeClock) After(d time.Duration) <-chan time.Time {
+	fc.now = fc.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- fc.now
+	return ch
+}
+
+func TestTimeBuiltins(t *testing.T) {
+	runTestCases(t, []sTestCase{
+		sTestCase{`print "$1" [parse_time "2024-02-29T12:30:00Z"]`,
+			"2024-02-29T12:30:00Z", false},
+		sTestCase{`print "$1" [format_time [parse_time "29/02/2024" "02/01/2006"] date]`,
+			"2024-02-29", false},
+		sTestCase{`print "$1" [parse_time "2024-02-29 12:30:00" datetime "Europe/Brussels"]`,
+			"2024-02-29T12:30:00+01:00", false},
+		sTestCase{`print "$1" [tz [parse_time "2024-07-01T12:00:00Z"] "Asia/Tokyo"]`,
+			"2024-07-01T21:00:00+09:00", false},
+		sTestCase{`print "$1" [tadd [parse_time "2024-02-28T23:00:00Z"] "2h"]`,
+			"2024-02-29T01:00:00Z", false},
+		sTestCase{`print "$1" [tsub [parse_time "2024-03-01T00:00:00Z"] 1500]`,
+			"2024-02-29T23:59:58.5Z", false},
+		sTestCase{`print "$1" [tdiff [parse_time "2024-03-01T00:00:00Z"] [parse_time "2024-02-29T22:30:00Z"]]`,
+			"1h30m0s", false},
+		sTestCase{`print "$1" [tadd [duration "1m"] [duration 500]]`,
+			"1m0.5s", false},
+		sTestCase{`print "$1 $2" [cmp [parse_time "2024-01-01T00:00:00Z"] [parse_time "2024-01-02T00:00:00Z"]] [eq [parse_time "2024-01-01T01:00:00+01:00"] [parse_time "2024-01-01T00:00:00Z"]]`,
+			"-1 true", false},
+		sTestCase{`print "$1 $2" [tunix [parse_time "1970-01-01T00:01:00Z"]] [tunix 60]`,
+			"60 1970-01-01T00:01:00Z", false},
+		sTestCase{`parse_time "not a time"`, "", true},
+		sTestCase{`tz [now] "Nowhere/Nothing"`, "", true},
+	})
+}
+
+func TestTimeClock(t *testing.T) {
+	env, out := newTestEnvironment()
+	start := time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC)
+	env.Clock = &fakeClock{now: start}
+	parsed, perr := Parse(`let t [now]
+sleep "1h"
+sleep 1000
+print "$1 $2" $t [tdiff [now] $t]
+`)
+	if perr != nil {
+		t.Fatalf("error: unexpected parse error: %v", perr)
+	}
+	_, eff := parsed.Eval(env)
+	if eff != nil {
+		t.Fatalf("error: unexpected effect: %v", eff)
+	}
+	expect := "2024-02-29T12:00:00Z 1h0m1s"
+	if out.String() != expect {
+		t.Errorf("error: output not as expected: %q <-> %q", out.String(), expect)
+	}
+
+	env.Clock = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	env.Context = ctx
+	if err := env.Sleep(time.Hour); err == nil || !IsKind(err, QuotaExceeded) {
+		t.Errorf("error: expected sleep to be canceled: %v", err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	env.Context = ctx
+	parsed, _ = Parse("try { sleep \"1h\" } catch e QuotaExceeded { }\n")
+	if _, eff := parsed.Eval(env); eff != nil {
+		t.Errorf("error: expected canceled sleep to be caught: %v", eff)
+	}
+}
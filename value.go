@@ -1,5 +1,7 @@
 package tgtl
 
+import "errors"
+
 type Tgtl struct {
 	index int
 	input string
@@ -57,6 +59,13 @@ type Error struct {
 	Message  string
 	Index    int
 	Children List
+	// Kind classifies the error, such as ArgumentError or TypeError.
+	// Errors without a kind are of kind Error.
+	Kind Type
+	// Payload is an optional value that is passed with the error.
+	Payload Value
+	// Cause is the error that caused this error, if any.
+	Cause error
 }
 
 type List []Value
@@ -75,6 +84,24 @@ func (m Map) SortedKeys() List {
 	return m.Keys().SortStrings()
 }
 
+// Values returns the values of the map, sorted by key.
+func (m Map) Values() List {
+	res := List{}
+	for _, k := range m.SortedKeys() {
+		res = append(res, m[k.String()])
+	}
+	return res
+}
+
+// Pairs returns a list of [list key value] lists, sorted by key.
+func (m Map) Pairs() List {
+	res := List{}
+	for _, k := range m.SortedKeys() {
+		res = append(res, List{k, m[k.String()]})
+	}
+	return res
+}
+
 type Getter struct {
 	Key Value
 }
@@ -168,12 +195,16 @@ func (iv Int) String() string {
 }
 
 func (lv Map) String() string {
-	aid := "[map "
-	for k, v := range lv {
+	aid := "[map"
+	for _, k := range lv.SortedKeys() {
 		aid += " "
-		aid += k
+		aid += k.String()
 		aid += " "
-		aid += v.String()
+		if v := lv[k.String()]; v == nil {
+			aid += "nil"
+		} else {
+			aid += v.String()
+		}
 	}
 	aid += "]"
 	return aid
@@ -209,6 +240,10 @@ func (ev *Error) Flow() Flow {
 	return FailFlow
 }
 
+// Unwrap implements Effect and returns the error itself. Because of
+// this it does not have the signature errors.Unwrap expects. Use
+// errors.Is and errors.As, which follow the cause of the error with
+// the Is and As methods, or Err to get the cause with errors.Unwrap.
 func (ev *Error) Unwrap() Value {
 	if ev == nil {
 		return nil
@@ -217,7 +252,7 @@ func (ev *Error) Unwrap() Value {
 }
 
 func NewError(message string, index int, children ...Value) *Error {
-	return &Error{message, index, children}
+	return &Error{Message: message, Index: index, Children: children}
 }
 
 func ErrorFromString(message string) *Error {
@@ -228,12 +263,21 @@ func ErrorFromError(err error, children ...Value) *Error {
 	if err == nil {
 		return nil
 	}
-	return NewError(err.Error(), -1, children...)
+	res := NewError(err.Error(), -1, children...)
+	res.Cause = err
+	// Keep the kind and payload of wrapped errors.
+	var cause *Error
+	if errors.As(err, &cause) {
+		res.Kind = cause.Kind
+		res.Payload = cause.Payload
+	}
+	return res
 }
 
 // Break is used for break flows
 type Break struct {
-	Value // value returned by break
+	Value      // value returned by break
+	Label Word // label of the loop to break, if any
 }
 
 func (bv Break) Flow() Flow {
@@ -257,6 +301,47 @@ func (bv Return) Unwrap() Value {
 	return bv.Value
 }
 
+// Continue is used for continue flows
+type Continue struct {
+	Label Word // label of the loop to continue, if any
+}
+
+func (cv Continue) Flow() Flow {
+	return ContinueFlow
+}
+
+func (cv Continue) Unwrap() Value {
+	return nil
+}
+
+// Exit is used for exit flows
+type Exit struct {
+	Code Int // exit code
+}
+
+func (ev Exit) Flow() Flow {
+	return ExitFlow
+}
+
+func (ev Exit) Unwrap() Value {
+	return ev.Code
+}
+
+// Suspend is used for suspend flows
+type Suspend struct {
+	Label Value          // label of the checkpoint, if any
+	Name  Word           // variable to set to the value it is resumed with, if any
+	stack []continuation // how to continue the script, innermost first
+}
+
+func (sv Suspend) Flow() Flow {
+	return SuspendFlow
+}
+
+func (sv Suspend) Unwrap() Value {
+	return sv.Label
+}
+
 // Rescue is used to evaluate rescue commands
 type Rescue struct {
 	Block // A rescue is a special block
@@ -268,9 +353,8 @@ func (r Rescue) Eval(env *Environment, args ...Value) (Value, Effect) {
 	// ignore the stack depth
 	// protection here to be sure the
 	// rescue block is executed
-	_ = env.Push()
 	env.Rescuing = true
-	env.Printi("Rescuing.\n")
+	_ = env.Push()
 	defer env.Pop()
 	defer func() {
 		env.Rescuing = false
@@ -351,8 +435,98 @@ func (cv Comment) Eval(env *Environment, args ...Value) (Value, Effect) {
 }
 
 func (bv Block) Eval(env *Environment, args ...Value) (Value, Effect) {
-	var res Value
-	var eff Effect
+	res, eff, _ := bv.eval(env, false, args...)
+	return res, eff
+}
+
+// tailCall is a call of a defined procedure in tail position.
+type tailCall struct {
+	Defined
+	args List
+}
+
+func (tc *tailCall) Flow() Flow {
+	return tailFlow
+}
+
+func (tc *tailCall) Unwrap() Value {
+	return nil
+}
+
+// tail returns the tail call for the command if it calls a defined
+// procedure by name, or if it returns the result of such a call.
+// Otherwise it returns nil.
+func (env *Environment) tail(cv Command, args ...Value) (*tailCall, Effect) {
+	name, ok := cv.Order.(Word)
+	if !ok {
+		return nil, nil
+	}
+	// return [f ...] is a tail call of f.
+	if (name == "return" || name == "ret") && len(cv.Parameters) == 1 {
+		if ev, ok := cv.Parameters[0].(Evaluation); ok {
+			return env.tail(ev.Command, args...)
+		}
+	}
+	dv, ok := env.Lookup(name.String()).(Defined)
+	if !ok {
+		return nil, nil
+	}
+	if err := env.Canceled(); err != nil {
+		_, eff := env.Fail(err)
+		return nil, eff
+	}
+	eargs, eff := cv.Parameters.Eval(env, args...)
+	if eff != nil {
+		return nil, eff
+	}
+	return &tailCall{dv, eargs.(List)}, nil
+}
+
+// evalTail evaluates the statement in tail position. If it is a tail
+// call, or a branch such as if that evaluates a block that ends with a
+// tail call, the call is returned in stead of evaluated.
+func (env *Environment) evalTail(s Value, args ...Value) (Value, Effect, *tailCall) {
+	cv, ok := s.(Command)
+	if !ok {
+		res, eff := s.Eval(env, args...)
+		return res, eff, nil
+	}
+	call, eff := env.tail(cv, args...)
+	if call != nil || eff != nil {
+		return nil, eff, call
+	}
+	// The command pushes one frame, so a branch that is called by it
+	// evaluates it's block at this depth.
+	outer := env.tailDepth
+	env.tailDepth = env.Depth() + 1
+	res, eff := cv.Eval(env, args...)
+	env.tailDepth = outer
+	if call, ok := eff.(*tailCall); ok {
+		return nil, nil, call
+	}
+	return res, eff, nil
+}
+
+// evalBranch evaluates the block that a branch such as if or switch
+// chose. If the branch is in tail position, so is the block, and a
+// tail call the block ends with is returned as the effect.
+func (env *Environment) evalBranch(block Block, args ...Value) (Value, Effect) {
+	if env.tailDepth == 0 || env.tailDepth != env.Depth() {
+		res, eff := block.Eval(env, args...)
+		return res, env.suspended(eff, continuation{kind: branchContinuation})
+	}
+	env.tailDepth = 0
+	res, eff, call := block.eval(env, true, args...)
+	if call != nil {
+		return nil, call
+	}
+	return res, env.suspended(eff, continuation{kind: branchContinuation})
+}
+
+// eval evaluates the block. If allowTail is true and the last
+// statement is a tail call, it is returned in stead of evaluated,
+// unless the top frame is still needed for a rescuer or deferred blocks.
+func (bv Block) eval(env *Environment, allowTail bool, args ...Value) (Value, Effect, *tailCall) {
 	// set parameters to $1 ... $(len(args))
 	for i, a := range args {
 		name := Itoa(i + 1)
@@ -362,24 +536,45 @@ func (bv Block) Eval(env *Environment, args ...Value) (Value, Effect) {
 	// and $argv to arguments as well
 	env.Define("argc", Int(len(args)), 0)
 	env.Define("argv", List(args), 0)
-	for _, s := range bv.Statements {
+	return bv.evalFrom(env, allowTail, 0, args...)
+}
+
+// evalFrom evaluates the statements of the block from the index on.
+func (bv Block) evalFrom(env *Environment, allowTail bool, from int, args ...Value) (Value, Effect, *tailCall) {
+	var res Value
+	var eff Effect
+	for i := from; i < len(bv.Statements); i++ {
+		s := bv.Statements[i]
 		// Call the statement.
-		res, eff = s.Eval(env, args...)
+		if allowTail && i == len(bv.Statements)-1 {
+			var call *tailCall
+			res, eff, call = env.evalTail(s, args...)
+			if call != nil {
+				frame := env.Top()
+				if frame.Rescuer == nil && len(frame.Deferred) == 0 {
+					return nil, nil, call
+				}
+				res, eff = call.Defined.Eval(env, call.args...)
+			}
+		} else {
+			res, eff = s.Eval(env, args...)
+		}
 		// if the flow is not normal anymore,
-		// end the block execution at this point
+		// end the block execution at this point.
+		// Breaks and continues pass on to the loop.
 		if eff != nil && eff.Flow() > NormalFlow {
-			// If it was a break, unwrap and done,
-			if eff.Flow() <= BreakFlow {
-				return eff.Unwrap(), nil
-			} else if eff.Flow() == FailFlow {
+			if eff.Flow() == FailFlow {
 				// If it is a fail try to rescue it
-				return env.Rescue(res, eff)
+				res, eff = env.Rescue(res, eff)
 			}
-			return res, eff
+			// A suspended script continues with the next statement.
+			eff = env.suspended(eff, continuation{kind: blockContinuation,
+				block: bv, next: i + 1})
+			return res, eff, nil
 		}
 		env.Define("RESULT", res, 0)
 	}
-	return res, eff
+	return res, eff, nil
 }
 
 func (pv Proc) Eval(env *Environment, args ...Value) (Value, Effect) {
@@ -387,6 +582,9 @@ func (pv Proc) Eval(env *Environment, args ...Value) (Value, Effect) {
 }
 
 func (cv Command) Eval(env *Environment, args ...Value) (Value, Effect) {
+	if err := env.Canceled(); err != nil {
+		return env.Fail(err)
+	}
 	val, eff := cv.Order.Eval(env)
 	if eff != nil || val == nil {
 		return val, eff
@@ -412,7 +610,11 @@ func (cv Command) Eval(env *Environment, args ...Value) (Value, Effect) {
 	if eff != nil {
 		return nil, eff
 	}
-	return eva.Eval(env, eargs.(List)...)
+	val, eff = eva.Eval(env, eargs.(List)...)
+	if eff != nil && eff.Flow() == SuspendFlow {
+		return env.suspends(name, eva, val, eff)
+	}
+	return val, eff
 }
 
 func (gv Getter) Eval(env *Environment, args ...Value) (Value, Effect) {
@@ -431,26 +633,82 @@ func (ev Evaluation) Eval(env *Environment, args ...Value) (Value, Effect) {
 	}
 	defer env.Pop()
 	val, eff := ev.Command.Eval(env, args...)
+	if eff != nil && eff.Flow() == SuspendFlow {
+		// The command that needs the value could not be continued.
+		return env.FailString("checkpoint: cannot suspend in an evaluation")
+	}
 	return val, eff
 }
 
+// Eval calls the defined procedure. Calls of defined procedures in
+// tail position reuse the frame of the procedure, so they do not count
+// towards the frame limit. A call is in tail position if it is the last
+// statement of the procedure, if it is returned by it, or if it is in
+// tail position in the block of an if or switch that is. If the
+// environment is restricted, tail calls are limited like the iterations
+// of bounded loops in stead, so evaluation still terminates.
 func (dv Defined) Eval(env *Environment, args ...Value) (Value, Effect) {
+	for calls := Int(1); ; calls++ {
+		val, eff, call := dv.call(env, args...)
+		if call == nil {
+			return val, eff
+		}
+		if err := env.CheckLoops(calls); err != nil && env.Restricted() {
+			return env.Rescue(env.Fail(err))
+		}
+		dv, args = call.Defined, call.args
+	}
+}
+
+// call calls the procedure once, or returns the tail call it ends with.
+func (dv Defined) call(env *Environment, args ...Value) (Value, Effect, *tailCall) {
 	err := env.Push()
 	// stack depth protection
 	if err != nil {
-		return env.Rescue(env.Fail(err))
+		val, eff := env.Rescue(env.Fail(err))
+		return val, eff, nil
 	}
+	defer env.Pop()
+	defer env.ScopeLabels()()
+	env.Top().Procedure = true
 	if len(dv.Params) > len(args) {
-		return env.FailString("Not enough arguments")
+		val, eff := env.FailKind(ArgumentError, "Not enough arguments")
+		return val, eff, nil
+	}
+	// The arguments are copied, so the procedure cannot modify the lists,
+	// maps and dicts of it's caller. Frozen values are shared as they are.
+	copied := make(List, len(args))
+	for i, arg := range args {
+		copied[i] = isolate(arg)
 	}
+	args = copied
 	for i := 0; i < len(dv.Params); i++ {
 		env.Define(dv.Params[i].String(), args[i], 0)
 	}
 	// $0 contains the name of the defined procedure
 	env.Define("0", String(dv.Name), 0)
-	defer env.Pop()
-	val, eff := dv.Block.Eval(env, args...)
-	if eff == nil || eff.Flow() < ReturnFlow {
+	val, eff, call := dv.Block.eval(env, true, args...)
+	if call != nil {
+		return nil, nil, call
+	}
+	if eff != nil && eff.Flow() == SuspendFlow {
+		// The deferred blocks run when the script is resumed.
+		return val, env.suspended(eff, continuation{kind: procedureContinuation}), nil
+	}
+	val, eff = env.procedureResult(val, eff)
+	return val, eff, nil
+}
+
+// procedureResult returns the result of a procedure from the result of
+// it's block, after running the deferred blocks.
+func (env *Environment) procedureResult(val Value, eff Effect) (Value, Effect) {
+	val, eff = env.RunDeferred(val, eff)
+	// break and continue fail outside of a loop, but if a builtin
+	// breaks or continues anyway, that ends the procedure.
+	if eff == nil || eff.Flow() < ReturnFlow || eff.Flow() == ContinueFlow {
+		if eff != nil && eff.Flow() == BreakFlow {
+			return eff.Unwrap(), nil
+		}
 		return val, nil
 	} else if eff.Flow() == ReturnFlow {
 		return eff.Unwrap(), nil